@@ -0,0 +1,138 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"sync/atomic"
+)
+
+// connIDs numbers the Conns of the process, starting at 1.
+var connIDs atomic.Int64
+
+// Conn wraps sql.Conn. Every event of a Conn, and of the transactions and
+// statements made from it, carries its ConnID.
+type Conn struct {
+	conn *sql.Conn
+	core *core
+	id   int64
+	res  *resource
+}
+
+func (d *DB) Conn(ctx context.Context) (*Conn, error) {
+	e := newEvent(OriginDB, OpConn, "", nil)
+	ctx = d.core.before(ctx, e)
+	conn, err := d.db.Conn(ctx)
+	e.Err = err
+	var c *Conn
+	if err == nil {
+		c = &Conn{
+			conn: conn,
+			core: d.core,
+			id:   connIDs.Add(1),
+		}
+		e.ConnID = c.id
+		if d.core.leaks != nil {
+			c.res = d.core.track("conn", e, nil, nil)
+			runtime.SetFinalizer(c, (*Conn).finalize)
+		}
+	}
+	d.core.after(ctx, e)
+	return c, err
+}
+
+func (c *Conn) finalize() {
+	if c.res.open() {
+		c.core.leaked(c.res, "conn not closed")
+		c.res.close()
+		c.conn.Close()
+	}
+}
+
+// ID is the ConnID of the events of c.
+func (c *Conn) ID() int64 {
+	return c.id
+}
+
+func (c *Conn) event(op Op, query string, args []interface{}) *Event {
+	e := newEvent(OriginConn, op, query, args)
+	e.ConnID = c.id
+	return e
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e := c.event(OpExec, query, args)
+	ctx = c.core.before(ctx, e)
+	r, err := c.conn.ExecContext(ctx, e.Query, args...)
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	c.core.after(ctx, e)
+	return r, err
+}
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := c.event(OpQuery, query, args)
+	ctx = c.core.before(ctx, e)
+	rows, err := c.conn.QueryContext(ctx, e.Query, args...)
+	e.Err = err
+	c.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, rows, e, c.core), nil
+}
+func (c *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	e := c.event(OpQueryRow, query, args)
+	ctx = c.core.before(ctx, e)
+	return newRow(ctx, c.conn.QueryRowContext(ctx, e.Query, args...), e, c.core)
+}
+func (c *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	e := c.event(OpPrepare, query, nil)
+	ctx = c.core.before(ctx, e)
+	s, err := c.conn.PrepareContext(ctx, query)
+	e.Err = err
+	c.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newStmt(c.core, s, query, c.id, nil), nil
+}
+func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (t *Tx, err error) {
+	e := c.event(OpBegin, "", nil)
+	ctx = c.core.before(ctx, e)
+	tx, err := c.conn.BeginTx(ctx, opts)
+	e.Err = err
+	if err == nil {
+		t = newTx(ctx, c.core, tx, e, c.id, opts)
+	}
+	c.core.after(ctx, e)
+	return
+}
+func (c *Conn) PingContext(ctx context.Context) error {
+	e := c.event(OpPing, "", nil)
+	ctx = c.core.before(ctx, e)
+	err := c.conn.PingContext(ctx)
+	e.Err = err
+	c.core.after(ctx, e)
+	return err
+}
+
+// Raw runs f with the driver connection. The event covers the whole call.
+func (c *Conn) Raw(f func(driverConn interface{}) error) error {
+	e := c.event(OpRaw, "", nil)
+	ctx := c.core.before(context.Background(), e)
+	err := c.conn.Raw(f)
+	e.Err = err
+	c.core.after(ctx, e)
+	return err
+}
+func (c *Conn) Close() error {
+	e := c.event(OpClose, "", nil)
+	ctx := c.core.before(context.Background(), e)
+	err := c.conn.Close()
+	c.res.close()
+	e.Err = err
+	c.core.after(ctx, e)
+	return err
+}
@@ -3,15 +3,25 @@ package sqlwrapper
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"net"
-	"os"
+	"sync"
 	"time"
+)
 
-	log "github.com/sirupsen/logrus"
+var (
+	ipOnce sync.Once
+	ip     string
 )
 
-var ip string
+// externalIP looks the address up on first use rather than at import time.
+func externalIP() string {
+	ipOnce.Do(func() {
+		ip, _ = getExternalIP()
+	})
+	return ip
+}
 
 func getExternalIP() (string, error) {
 	ifaces, err := net.Interfaces()
@@ -50,386 +60,140 @@ func getExternalIP() (string, error) {
 	return "", errors.New("are you connected to the network?")
 }
 
-func init() {
-	// Log as JSON instead of the default ASCII formatter.
-	log.SetFormatter(&log.JSONFormatter{})
-
-	// Output to stdout instead of the default stderr
-	// Can be any io.Writer, see below for File example
-	log.SetOutput(os.Stdout)
-
-	// Only log the warning severity or above.
-	log.SetLevel(log.DebugLevel)
-	ip, _ = getExternalIP()
-}
-
-type Tx struct {
-	tx    *sql.Tx
-	debug bool
-	slow  time.Duration
+type DB struct {
+	db   *sql.DB
+	core *core
 }
 
-func (t *Tx) Commit() error {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if t.debug || total >= t.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("tx commit")
-		}
-	}()
-	return t.tx.Commit()
-}
-func (t *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if t.debug || total >= t.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"ip":       ip,
-				"sql":      query,
-				"args":     args,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("tx exec")
-		}
-	}()
-	return t.tx.Exec(query, args...)
-}
-func (t *Tx) Prepare(query string) (*Stmt, error) {
-	s, err := t.tx.Prepare(query)
-	if err != nil {
-		return nil, err
+// WrapperDB is kept for compatibility; new code should use Wrap or Open.
+// Events go to the first non-nil logger given, or to a private JSON logger
+// on stdout when there is none. A zero slow is ThresholdDisabled: unlike
+// earlier versions, it no longer logs every operation.
+func WrapperDB(db *sql.DB, debug bool, slow time.Duration, logger ...Logger) (d *DB) {
+	if slow < 0 {
+		slow = 0
 	}
-	stmt := &Stmt{
-		stmt:    s,
-		debug:   t.debug,
-		prepare: query,
-		slow:    t.slow,
-	}
-	return stmt, nil
-}
-func (t *Tx) Rollback() error {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if t.debug || total >= t.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("tx rollback")
+	opts := []Option{WithDebug(debug), WithSlowThreshold(slow)}
+	for _, l := range logger {
+		if l != nil {
+			opts = append(opts, WithLogger(l))
+			break
 		}
-	}()
-	return t.tx.Rollback()
-}
-func (t *Tx) Stmt(stmt *Stmt) *Stmt {
-	s := t.tx.Stmt(stmt.stmt)
-	stmt.stmt = s
-	return stmt
-}
-func (t *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if t.debug || total >= t.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"sql":      query,
-				"args":     args,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("tx query")
-		}
-	}()
-	return t.tx.Query(query, args...)
-}
-func (t *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if t.debug || total >= t.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"sql":      query,
-				"args":     args,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("tx query row")
-		}
-	}()
-	return t.tx.QueryRow(query, args...)
-}
-
-type Stmt struct {
-	stmt    *sql.Stmt
-	prepare string
-	debug   bool
-	slow    time.Duration
-}
-
-func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if s.debug || total >= s.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      s.prepare,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("stmt query row")
-		}
-	}()
-	return s.stmt.Exec(args...)
-}
-func (s *Stmt) Query(args ...interface{}) (*sql.Rows, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if s.debug || total >= s.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      s.prepare,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("stmt query")
-		}
-	}()
-	return s.stmt.Query(args...)
-}
-func (s *Stmt) QueryRow(args ...interface{}) *sql.Row {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if s.debug || total >= s.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      s.prepare,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("stmt query row")
-		}
-	}()
-	return s.stmt.QueryRow(args...)
-}
-func (s *Stmt) Close() error {
-	return s.stmt.Close()
-}
-
-type DB struct {
-	db    *sql.DB
-	slow  time.Duration
-	debug bool
+	}
+	d, _ = Wrap(db, opts...)
+	return
 }
 
-func WrapperDB(db *sql.DB, debug bool, slow time.Duration) (d *DB) {
-
-	return &DB{
-		db:    db,
-		slow:  slow,
-		debug: debug,
-	}
+// Config returns a copy of the configuration of d, with the current values
+// of the settings changed at runtime.
+func (d *DB) Config() Config {
+	c := *d.core.cfg
+	s := d.core.live.Load()
+	c.Debug, c.SlowThreshold, c.LogLevel = s.debug, s.slow, s.level
+	return c
 }
 func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db exec")
-		}
-	}()
-	return d.db.ExecContext(ctx, query, args...)
-
+	e := newEvent(OriginDB, OpExec, query, args)
+	ctx = d.core.before(ctx, e)
+	r, err := d.db.ExecContext(ctx, e.Query, args...)
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	d.core.after(ctx, e)
+	return r, err
 }
 func (d *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db exec")
-		}
-	}()
-	return d.db.Exec(query, args...)
-
-}
-
-func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db query")
-		}
-	}()
-	return d.db.QueryContext(ctx, query, args...)
+	return d.ExecContext(context.Background(), query, args...)
+}
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := newEvent(OriginDB, OpQuery, query, args)
+	ctx = d.core.before(ctx, e)
+	rows, err := d.db.QueryContext(ctx, e.Query, args...)
+	e.Err = err
+	d.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, rows, e, d.core), nil
 }
-func (d *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db query")
-		}
-	}()
-	return d.db.Query(query, args...)
+func (d *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
 }
-
-func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db query row")
-		}
-	}()
-	return d.db.QueryRowContext(ctx, query, args...)
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	e := newEvent(OriginDB, OpQueryRow, query, args)
+	ctx = d.core.before(ctx, e)
+	return newRow(ctx, d.db.QueryRowContext(ctx, e.Query, args...), e, d.core)
 }
-func (d *DB) Ping() error {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("ping ")
-		}
-	}()
-	return d.db.Ping()
+func (d *DB) QueryRow(query string, args ...interface{}) *Row {
+	return d.QueryRowContext(context.Background(), query, args...)
 }
 func (d *DB) PingContext(ctx context.Context) error {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("ping ")
-		}
-	}()
-	return d.db.PingContext(ctx)
+	e := newEvent(OriginDB, OpPing, "", nil)
+	ctx = d.core.before(ctx, e)
+	err := d.db.PingContext(ctx)
+	e.Err = err
+	d.core.after(ctx, e)
+	return err
 }
-func (d *DB) QueryRow(query string, args ...interface{}) *sql.Row {
-	st := time.Now()
-	defer func() {
-		et := time.Now()
-		total := et.Sub(st)
-		if d.debug || total >= d.slow {
-			log.WithFields(log.Fields{
-				"use-time": total.String(),
-				"args":     args,
-				"sql":      query,
-				"ip":       ip,
-				"name":     "syhlion/sqlwrapper",
-			}).Debug("db query row")
-		}
-	}()
-	return d.db.QueryRow(query, args...)
+func (d *DB) Ping() error {
+	return d.PingContext(context.Background())
 }
 func (d *DB) Close() error {
-	return d.db.Close()
+	e := newEvent(OriginDB, OpClose, "", nil)
+	ctx := d.core.before(context.Background(), e)
+	err := d.db.Close()
+	e.Err = err
+	d.core.after(ctx, e)
+	d.core.close()
+	if m := d.core.cfg.Metrics; m != nil {
+		m.removePool(d.core.cfg.Name, d.db)
+	}
+	return err
 }
 func (d *DB) BeginTX(ctx context.Context, opts *sql.TxOptions) (t *Tx, err error) {
+	e := newEvent(OriginDB, OpBegin, "", nil)
+	ctx = d.core.before(ctx, e)
 	tx, err := d.db.BeginTx(ctx, opts)
-	if err != nil {
-		return
-	}
-	t = &Tx{
-		tx:    tx,
-		debug: d.debug,
-		slow:  d.slow,
+	e.Err = err
+	if err == nil {
+		t = newTx(ctx, d.core, tx, e, 0, opts)
 	}
+	d.core.after(ctx, e)
 	return
 }
-
 func (d *DB) Begin() (t *Tx, err error) {
-	tx, err := d.db.Begin()
-	if err != nil {
-		return
-	}
-	t = &Tx{
-		tx:    tx,
-		debug: d.debug,
-		slow:  d.slow,
-	}
-	return
+	return d.BeginTX(context.Background(), nil)
 }
 func (d *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	e := newEvent(OriginDB, OpPrepare, query, nil)
+	ctx = d.core.before(ctx, e)
 	s, err := d.db.PrepareContext(ctx, query)
+	e.Err = err
+	d.core.after(ctx, e)
 	if err != nil {
 		return nil, err
 	}
-	return &Stmt{
-		stmt:    s,
-		prepare: query,
-		debug:   d.debug,
-		slow:    d.slow,
-	}, nil
+	return newStmt(d.core, s, query, 0, nil), nil
 }
 func (d *DB) Prepare(query string) (*Stmt, error) {
-	s, err := d.db.Prepare(query)
-	if err != nil {
-		return nil, err
-	}
-	return &Stmt{
-		stmt:    s,
-		prepare: query,
-		debug:   d.debug,
-		slow:    d.slow,
-	}, nil
+	return d.PrepareContext(context.Background(), query)
+}
+func (d *DB) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+func (d *DB) SetMaxOpenConns(n int) {
+	d.db.SetMaxOpenConns(n)
+}
+func (d *DB) SetMaxIdleConns(n int) {
+	d.db.SetMaxIdleConns(n)
+}
+func (d *DB) SetConnMaxLifetime(t time.Duration) {
+	d.db.SetConnMaxLifetime(t)
+}
+func (d *DB) SetConnMaxIdleTime(t time.Duration) {
+	d.db.SetConnMaxIdleTime(t)
+}
+func (d *DB) Driver() driver.Driver {
+	return d.db.Driver()
 }
@@ -0,0 +1,594 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Register makes base available to sql.Open under name, with every
+// connection, statement, transaction and result set it produces running
+// through the same hooks and logging as a wrapped DB. Like sql.Register it
+// panics if name is already taken.
+func Register(name string, base driver.Driver, opts ...Option) error {
+	if base == nil {
+		return errors.New("sqlwrapper: driver must not be nil")
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+	if cfg.Dialect == "" {
+		cfg.Dialect = detectDialect(base)
+	}
+	if cfg.Metrics != nil {
+		if err := cfg.Metrics.addPool(cfg.Name, nil); err != nil {
+			return err
+		}
+	}
+	c := newCore(cfg)
+	c.start()
+	sql.Register(name, &wrappedDriver{driver: base, core: c})
+	return nil
+}
+
+// WrapConnector instruments c for use with sql.OpenDB.
+func WrapConnector(c driver.Connector, opts ...Option) (driver.Connector, error) {
+	if c == nil {
+		return nil, errors.New("sqlwrapper: connector must not be nil")
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Dialect == "" {
+		cfg.Dialect = detectDialect(c.Driver())
+	}
+	if cfg.Metrics != nil {
+		if err := cfg.Metrics.addPool(cfg.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	cr := newCore(cfg)
+	cr.start()
+	return &wrappedConnector{
+		connector: c,
+		driver:    &wrappedDriver{driver: c.Driver(), core: cr},
+		core:      cr,
+		owned:     true,
+	}, nil
+}
+
+type wrappedDriver struct {
+	driver driver.Driver
+	core   *core
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(c, d.core), nil
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedConnector{connector: c, driver: d, core: d.core}, nil
+	}
+	return &dsnConnector{name: name, driver: d}, nil
+}
+
+type wrappedConnector struct {
+	connector driver.Connector
+	driver    *wrappedDriver
+	core      *core
+	// owned is set for connectors made by WrapConnector, whose core lives
+	// and dies with them rather than with a registered driver.
+	owned bool
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(conn, c.core), nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *wrappedConnector) Close() error {
+	if c.owned {
+		c.core.close()
+		if m := c.core.cfg.Metrics; m != nil {
+			m.removePool(c.core.cfg.Name, nil)
+		}
+	}
+	if cl, ok := c.connector.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// dsnConnector mirrors the connector database/sql builds for drivers that do
+// not implement driver.DriverContext.
+type dsnConnector struct {
+	name   string
+	driver *wrappedDriver
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// driverConn wraps a driver.Conn. Optional interfaces the base connection
+// lacks are answered the way database/sql would answer them itself, except
+// for SessionResetter and Validator, whose mere presence changes how the
+// pool treats the connection; those are added by the driverConn* variants
+// only when the base implements them.
+type driverConn struct {
+	conn driver.Conn
+	core *core
+}
+
+func wrapConn(conn driver.Conn, c *core) driver.Conn {
+	dc := &driverConn{conn: conn, core: c}
+	_, r := conn.(driver.SessionResetter)
+	_, v := conn.(driver.Validator)
+	switch {
+	case r && v:
+		return &driverConnResetValidator{dc}
+	case r:
+		return &driverConnResetter{dc}
+	case v:
+		return &driverConnValidator{dc}
+	}
+	return dc
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *driverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	e := newEvent(OriginDriverConn, OpPrepare, query, nil)
+	ctx = c.core.before(ctx, e)
+	var s driver.Stmt
+	var err error
+	if pc, ok := c.conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.conn.Prepare(query)
+		if err == nil {
+			select {
+			default:
+			case <-ctx.Done():
+				s.Close()
+				s, err = nil, ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	c.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return wrapStmt(s, query, c), nil
+}
+
+func (c *driverConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *driverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *driverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	e := newEvent(OriginDriverConn, OpBegin, "", nil)
+	ctx = c.core.before(ctx, e)
+	var tx driver.Tx
+	var err error
+	if bt, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = bt.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		err = errors.New("sql: driver does not support non-default isolation level")
+	} else if opts.ReadOnly {
+		err = errors.New("sql: driver does not support read-only transactions")
+	} else {
+		tx, err = c.conn.Begin()
+		if err == nil {
+			select {
+			default:
+			case <-ctx.Done():
+				tx.Rollback()
+				tx, err = nil, ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	c.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return &driverTx{tx: tx, core: c.core}, nil
+}
+
+func (c *driverConn) Ping(ctx context.Context) error {
+	p, ok := c.conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	e := newEvent(OriginDriverConn, OpPing, "", nil)
+	ctx = c.core.before(ctx, e)
+	err := p.Ping(ctx)
+	e.Err = err
+	c.core.after(ctx, e)
+	return err
+}
+
+func (c *driverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, hasCtx := c.conn.(driver.ExecerContext)
+	ex, hasPlain := c.conn.(driver.Execer)
+	if !hasCtx && !hasPlain {
+		return nil, driver.ErrSkip
+	}
+	e := newEvent(OriginDriverConn, OpExec, query, namedArgs(args))
+	ctx = c.core.before(ctx, e)
+	var r driver.Result
+	var err error
+	if hasCtx {
+		r, err = ec.ExecContext(ctx, e.Query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			select {
+			default:
+				r, err = ex.Exec(e.Query, values)
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	c.core.after(ctx, e)
+	return r, err
+}
+
+func (c *driverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, hasCtx := c.conn.(driver.QueryerContext)
+	q, hasPlain := c.conn.(driver.Queryer)
+	if !hasCtx && !hasPlain {
+		return nil, driver.ErrSkip
+	}
+	e := newEvent(OriginDriverConn, OpQuery, query, namedArgs(args))
+	ctx = c.core.before(ctx, e)
+	var rows driver.Rows
+	var err error
+	if hasCtx {
+		rows, err = qc.QueryContext(ctx, e.Query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			select {
+			default:
+				rows, err = q.Query(e.Query, values)
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	c.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return wrapDriverRows(ctx, rows, e, c.core), nil
+}
+
+func (c *driverConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *driverConn) resetSession(ctx context.Context) error {
+	return c.conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *driverConn) isValid() bool {
+	return c.conn.(driver.Validator).IsValid()
+}
+
+type driverConnResetter struct{ *driverConn }
+
+func (c *driverConnResetter) ResetSession(ctx context.Context) error { return c.resetSession(ctx) }
+
+type driverConnValidator struct{ *driverConn }
+
+func (c *driverConnValidator) IsValid() bool { return c.isValid() }
+
+type driverConnResetValidator struct{ *driverConn }
+
+func (c *driverConnResetValidator) ResetSession(ctx context.Context) error {
+	return c.resetSession(ctx)
+}
+func (c *driverConnResetValidator) IsValid() bool { return c.isValid() }
+
+// driverStmt wraps a driver.Stmt. database/sql only consults the
+// connection's NamedValueChecker when the statement has none, so the
+// statement forwards to the connection itself.
+type driverStmt struct {
+	stmt  driver.Stmt
+	query string
+	conn  *driverConn
+}
+
+func wrapStmt(s driver.Stmt, query string, c *driverConn) driver.Stmt {
+	ds := &driverStmt{stmt: s, query: query, conn: c}
+	if _, ok := s.(driver.ColumnConverter); ok {
+		return &driverStmtConverter{ds}
+	}
+	return ds
+}
+
+func (s *driverStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *driverStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e := newEvent(OriginDriverStmt, OpExec, s.query, namedArgs(args))
+	ctx = s.conn.core.before(ctx, e)
+	var r driver.Result
+	var err error
+	if sc, ok := s.stmt.(driver.StmtExecContext); ok {
+		r, err = sc.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			select {
+			default:
+				r, err = s.stmt.Exec(values)
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	s.conn.core.after(ctx, e)
+	return r, err
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	e := newEvent(OriginDriverStmt, OpQuery, s.query, namedArgs(args))
+	ctx = s.conn.core.before(ctx, e)
+	var rows driver.Rows
+	var err error
+	if sc, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = sc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			select {
+			default:
+				rows, err = s.stmt.Query(values)
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+	e.Err = err
+	s.conn.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return wrapDriverRows(ctx, rows, e, s.conn.core), nil
+}
+
+func (s *driverStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+type driverStmtConverter struct{ *driverStmt }
+
+func (s *driverStmtConverter) ColumnConverter(idx int) driver.ValueConverter {
+	return s.stmt.(driver.ColumnConverter).ColumnConverter(idx)
+}
+
+type driverTx struct {
+	tx   driver.Tx
+	core *core
+}
+
+func (t *driverTx) Commit() error {
+	e := newEvent(OriginDriverTx, OpCommit, "", nil)
+	ctx := t.core.before(context.Background(), e)
+	err := t.tx.Commit()
+	e.Err = err
+	t.core.after(ctx, e)
+	return err
+}
+
+func (t *driverTx) Rollback() error {
+	e := newEvent(OriginDriverTx, OpRollback, "", nil)
+	ctx := t.core.before(context.Background(), e)
+	err := t.tx.Rollback()
+	e.Err = err
+	t.core.after(ctx, e)
+	return err
+}
+
+// driverRows reports a fetch event covering the time from the query to
+// Close.
+//
+// driver.RowsColumnScanner is not forwarded: database/sql stops calling
+// Next on rows that implement it, and Next is where rows are counted. The
+// base driver's Next still serves every scan.
+type driverRows struct {
+	rows driver.Rows
+	core *core
+	ctx  context.Context
+	e    *Event
+}
+
+func wrapDriverRows(ctx context.Context, rows driver.Rows, query *Event, c *core) driver.Rows {
+	e := newFetchEvent(query)
+	return &driverRows{
+		rows: rows,
+		core: c,
+		ctx:  c.before(ctx, e),
+		e:    e,
+	}
+}
+
+func (r *driverRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r *driverRows) Close() error {
+	err := r.rows.Close()
+	if r.e != nil {
+		r.e.Err = err
+		r.core.after(r.ctx, r.e)
+		r.e = nil
+	}
+	return err
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	err := r.rows.Next(dest)
+	if err == nil && r.e != nil {
+		if r.e.RowsRead == 0 {
+			r.e.FirstRow = time.Since(r.e.Start)
+		}
+		r.e.RowsRead++
+	}
+	return err
+}
+
+func (r *driverRows) HasNextResultSet() bool {
+	if nrs, ok := r.rows.(driver.RowsNextResultSet); ok {
+		return nrs.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *driverRows) NextResultSet() error {
+	nrs, ok := r.rows.(driver.RowsNextResultSet)
+	if !ok {
+		return io.EOF
+	}
+	err := nrs.NextResultSet()
+	if err == nil && r.e != nil {
+		r.e.ResultSets++
+	}
+	return err
+}
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func (r *driverRows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return anyType
+}
+
+func (r *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *driverRows) ColumnTypeLength(index int) (int64, bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *driverRows) ColumnTypeNullable(index int) (bool, bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *driverRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func namedArgs(args []driver.NamedValue) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	out := make([]interface{}, len(args))
+	for i, nv := range args {
+		if nv.Name != "" {
+			out[i] = sql.Named(nv.Name, nv.Value)
+		} else {
+			out[i] = nv.Value
+		}
+	}
+	return out
+}
+
+func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
+
+func valueToNamedValue(values []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(values))
+	for i, v := range values {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
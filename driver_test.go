@@ -0,0 +1,216 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+// openDriverFake opens d through WrapConnector, logging every event.
+func openDriverFake(t *testing.T, d *fakeDriver) (*sql.DB, *memLogger) {
+	t.Helper()
+	log := &memLogger{}
+	c, err := WrapConnector(d, WithLogger(log), WithDebug(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	t.Cleanup(func() { db.Close() })
+	return db, log
+}
+
+type resetConn struct{ *fakeConn }
+
+func (c resetConn) ResetSession(context.Context) error { return nil }
+
+type validConn struct{ *fakeConn }
+
+func (c validConn) IsValid() bool { return true }
+
+func TestWrapConnOptionalInterfaces(t *testing.T) {
+	cfg, err := newConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCore(cfg)
+	tests := []struct {
+		name         string
+		conn         driver.Conn
+		reset, valid bool
+	}{
+		{"plain", &fakeConn{}, false, false},
+		{"resetter", resetConn{&fakeConn{}}, true, false},
+		{"validator", validConn{&fakeConn{}}, false, true},
+		{"both", &fullConn{&fakeConn{}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := wrapConn(tt.conn, c)
+			if _, ok := wc.(driver.SessionResetter); ok != tt.reset {
+				t.Errorf("SessionResetter = %v, want %v", ok, tt.reset)
+			}
+			if _, ok := wc.(driver.Validator); ok != tt.valid {
+				t.Errorf("Validator = %v, want %v", ok, tt.valid)
+			}
+			// database/sql falls back by itself when these return ErrSkip
+			// or the base lacks them, so they are always present.
+			if _, ok := wc.(driver.ExecerContext); !ok {
+				t.Error("ExecerContext missing")
+			}
+			if _, ok := wc.(driver.QueryerContext); !ok {
+				t.Error("QueryerContext missing")
+			}
+			if _, ok := wc.(driver.ConnBeginTx); !ok {
+				t.Error("ConnBeginTx missing")
+			}
+			if _, ok := wc.(driver.NamedValueChecker); !ok {
+				t.Error("NamedValueChecker missing")
+			}
+		})
+	}
+}
+
+func TestDriverResetSession(t *testing.T) {
+	d := &fakeDriver{full: true}
+	db, _ := openDriverFake(t, d)
+	db.SetMaxOpenConns(1)
+	for i := 0; i < 3; i++ {
+		if err := db.QueryRow("select 1").Scan(new(int64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resets == 0 {
+		t.Error("ResetSession of the base connection never called")
+	}
+}
+
+func TestDriverFallsBackToPrepare(t *testing.T) {
+	db, log := openDriverFake(t, &fakeDriver{})
+	if _, err := db.Exec("insert into t values (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("select n from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	for msg, want := range map[string]int{
+		"driver conn exec":    0,
+		"driver conn query":   0,
+		"driver conn prepare": 2,
+		"driver stmt exec":    1,
+		"driver stmt query":   1,
+		"driver stmt fetch":   1,
+	} {
+		if got := len(log.find(msg)); got != want {
+			t.Errorf("%d %q events, want %d", got, msg, want)
+		}
+	}
+}
+
+func TestDriverExecQueryContext(t *testing.T) {
+	db, log := openDriverFake(t, &fakeDriver{full: true})
+	if _, err := db.Exec("insert into t values (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("select n from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	rows.Close()
+	for msg, want := range map[string]int{
+		"driver conn exec":    1,
+		"driver conn query":   1,
+		"driver conn fetch":   1,
+		"driver conn prepare": 0,
+	} {
+		if got := len(log.find(msg)); got != want {
+			t.Errorf("%d %q events, want %d", got, msg, want)
+		}
+	}
+	if f := log.find("driver conn fetch"); len(f) == 1 && f[0].fields["rows"] != int64(n) {
+		t.Errorf("rows = %v, want %d", f[0].fields["rows"], n)
+	}
+}
+
+func TestDriverBeginTx(t *testing.T) {
+	ctx := context.Background()
+	opts := &sql.TxOptions{ReadOnly: true}
+
+	db, _ := openDriverFake(t, &fakeDriver{})
+	if _, err := db.BeginTx(ctx, opts); err == nil {
+		t.Error("read-only transaction begun without ConnBeginTx")
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	d := &fakeDriver{full: true}
+	db, log := openDriverFake(t, d)
+	if tx, err = db.BeginTx(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.calls(); len(got) != 2 || got[0] != "begin" || got[1] != "commit" {
+		t.Errorf("driver calls = %q", got)
+	}
+	if len(log.find("driver conn begin")) != 1 || len(log.find("driver tx commit")) != 1 {
+		t.Error("begin or commit not logged")
+	}
+}
+
+type point struct{ x, y int }
+
+type convConn struct{ *fakeConn }
+
+func (c convConn) Prepare(q string) (driver.Stmt, error) {
+	return convStmt{&fakeStmt{d: c.d, q: q}}, nil
+}
+
+// convStmt converts every argument to "converted".
+type convStmt struct{ *fakeStmt }
+
+func (s convStmt) ColumnConverter(int) driver.ValueConverter { return convertAll{} }
+
+type convertAll struct{}
+
+func (convertAll) ConvertValue(interface{}) (driver.Value, error) { return "converted", nil }
+
+func TestDriverNamedValues(t *testing.T) {
+	// The connection's checker accepts types database/sql would reject.
+	d := &fakeDriver{full: true}
+	db, _ := openDriverFake(t, d)
+	if _, err := db.Exec("insert into t values (?)", point{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.lastArgs(); len(got) != 1 || got[0] != (point{1, 2}) {
+		t.Errorf("args = %v, want the point unchanged", got)
+	}
+
+	// Without a checker the statement's ColumnConverter is used.
+	d = &fakeDriver{conn: func(c *fakeConn) driver.Conn { return convConn{c} }}
+	db, _ = openDriverFake(t, d)
+	if _, err := db.Exec("insert into t values (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.lastArgs(); len(got) != 1 || got[0] != "converted" {
+		t.Errorf("args = %v, want the ColumnConverter applied", got)
+	}
+
+	// Without either the default conversion rejects the point.
+	db, _ = openDriverFake(t, &fakeDriver{})
+	if _, err := db.Exec("insert into t values (?)", point{1, 2}); err == nil {
+		t.Error("point accepted by the default converter")
+	}
+}
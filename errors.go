@@ -0,0 +1,120 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// ErrorClass groups the errors returned by wrapped calls.
+type ErrorClass string
+
+const (
+	ErrClassNone     ErrorClass = ""
+	ErrClassCanceled ErrorClass = "canceled"
+	ErrClassDeadline ErrorClass = "deadline exceeded"
+	ErrClassNoRows   ErrorClass = "no rows"
+	ErrClassBadConn  ErrorClass = "bad conn"
+	ErrClassTxDone   ErrorClass = "tx done"
+	ErrClassDriver   ErrorClass = "driver"
+
+	// Transient conflicts between transactions, see Retryable.
+	ErrClassDeadlock      ErrorClass = "deadlock"
+	ErrClassLockTimeout   ErrorClass = "lock timeout"
+	ErrClassSerialization ErrorClass = "serialization failure"
+)
+
+// Retryable reports whether running the transaction again may succeed.
+func (c ErrorClass) Retryable() bool {
+	switch c {
+	case ErrClassDeadlock, ErrClassLockTimeout, ErrClassSerialization:
+		return true
+	}
+	return false
+}
+
+// ClassifyError reports which ErrorClass err belongs to. Deadlocks, lock
+// wait timeouts and serialization failures are recognized from the MySQL
+// error number (1213, 1205) or the SQLSTATE (40P01, 40001) of the driver
+// error, or failing that from its message. Anything else that is not one
+// of the well-known database/sql or context errors is a driver error.
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrClassNone
+	case errors.Is(err, context.Canceled):
+		return ErrClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrClassDeadline
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrClassNoRows
+	case errors.Is(err, driver.ErrBadConn):
+		return ErrClassBadConn
+	case errors.Is(err, sql.ErrTxDone):
+		return ErrClassTxDone
+	}
+	if c := conflictClass(err); c != ErrClassNone {
+		return c
+	}
+	return ErrClassDriver
+}
+
+// conflictClass recognizes the errors of transactions getting in each
+// other's way without importing any driver.
+func conflictClass(err error) ErrorClass {
+	var st interface{ SQLState() string }
+	if errors.As(err, &st) {
+		switch st.SQLState() {
+		case "40P01":
+			return ErrClassDeadlock
+		case "40001":
+			return ErrClassSerialization
+		}
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch mysqlNumber(e) {
+		case 1213:
+			return ErrClassDeadlock
+		case 1205:
+			return ErrClassLockTimeout
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "error 1213"), strings.Contains(msg, "deadlock"),
+		strings.Contains(msg, "sqlstate 40p01"):
+		return ErrClassDeadlock
+	case strings.Contains(msg, "error 1205"), strings.Contains(msg, "lock wait timeout"):
+		return ErrClassLockTimeout
+	case strings.Contains(msg, "sqlstate 40001"), strings.Contains(msg, "could not serialize access"):
+		return ErrClassSerialization
+	}
+	return ErrClassNone
+}
+
+// mysqlNumber returns the Number field of a MySQL driver error, or 0.
+func mysqlNumber(err error) uint64 {
+	v := reflect.ValueOf(err)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+	f := v.FieldByName("Number")
+	switch f.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f.Uint()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := f.Int(); n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,248 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDriver is an in-memory driver for tests. Its connections only
+// implement the methods driver.Conn requires; fullConn adds the optional
+// interfaces. Every statement, begin, commit and rollback is recorded.
+type fakeDriver struct {
+	// full makes Open return fullConns.
+	full bool
+	// conn, when set, wraps the plain connections Open returns.
+	conn func(*fakeConn) driver.Conn
+
+	mu  sync.Mutex
+	log []string
+	// args are the arguments of the last statement run.
+	args []driver.Value
+	// errs holds the errors to return for a statement, "begin", "commit"
+	// or "rollback", one per call.
+	errs   map[string][]error
+	resets int
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	c := &fakeConn{d: d}
+	switch {
+	case d.full:
+		return &fullConn{c}, nil
+	case d.conn != nil:
+		return d.conn(c), nil
+	}
+	return c, nil
+}
+
+// record logs q with its arguments and returns the next error queued for
+// it.
+func (d *fakeDriver) record(q string, args ...driver.Value) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, q)
+	d.args = args
+	if errs := d.errs[q]; len(errs) > 0 {
+		d.errs[q] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// fail queues errs for q.
+func (d *fakeDriver) fail(q string, errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.errs == nil {
+		d.errs = make(map[string][]error)
+	}
+	d.errs[q] = append(d.errs[q], errs...)
+}
+
+func (d *fakeDriver) calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+func (d *fakeDriver) lastArgs() []driver.Value {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.args
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) { return d.Open("") }
+func (d *fakeDriver) Driver() driver.Driver                        { return d }
+
+// openFake returns a DB over a new fakeDriver, closed when t ends.
+func openFake(t *testing.T, full bool, opts ...Option) (*DB, *fakeDriver) {
+	t.Helper()
+	d := &fakeDriver{full: full}
+	opts = append([]Option{WithLogger(NewNopLogger())}, opts...)
+	db, err := Wrap(sql.OpenDB(d), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(q string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d, q: q}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if err := c.d.record("begin"); err != nil {
+		return nil, err
+	}
+	return &fakeTx{d: c.d}, nil
+}
+
+// fullConn implements every optional interface wrapConn looks for.
+type fullConn struct {
+	*fakeConn
+}
+
+func (c *fullConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+func (c *fullConn) ExecContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	if err := c.d.record(q, values...); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+func (c *fullConn) QueryContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.d.record(q); err != nil {
+		return nil, err
+	}
+	return newFakeRows(q), nil
+}
+
+// CheckNamedValue accepts any value, as drivers with custom types do.
+func (c *fullConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+func (c *fullConn) ResetSession(context.Context) error {
+	c.d.mu.Lock()
+	c.d.resets++
+	c.d.mu.Unlock()
+	return nil
+}
+func (c *fullConn) IsValid() bool { return true }
+
+type fakeStmt struct {
+	d *fakeDriver
+	q string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.d.record(s.q, args...); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.d.record(s.q); err != nil {
+		return nil, err
+	}
+	return newFakeRows(s.q), nil
+}
+
+type fakeTx struct {
+	d *fakeDriver
+}
+
+func (t *fakeTx) Commit() error   { return t.d.record("commit") }
+func (t *fakeTx) Rollback() error { return t.d.record("rollback") }
+
+// fakeRows returns n rows of a single integer column in each of its result
+// sets.
+type fakeRows struct {
+	n, i int
+	// sets is the number of result sets after the current one.
+	sets int
+}
+
+// newFakeRows returns two rows, or rows without end when q contains
+// "endless", in two result sets when q contains "two sets".
+func newFakeRows(q string) *fakeRows {
+	r := &fakeRows{n: 2}
+	if strings.Contains(q, "endless") {
+		r.n = math.MaxInt
+	}
+	if strings.Contains(q, "two sets") {
+		r.sets = 1
+	}
+	return r
+}
+
+func (r *fakeRows) HasNextResultSet() bool { return r.sets > 0 }
+func (r *fakeRows) NextResultSet() error {
+	if r.sets == 0 {
+		return io.EOF
+	}
+	r.sets--
+	r.i = 0
+	return nil
+}
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= r.n {
+		return io.EOF
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	return nil
+}
+
+// memLogger records log entries for tests.
+type memLogger struct {
+	mu      sync.Mutex
+	entries []memEntry
+}
+
+type memEntry struct {
+	level  Level
+	msg    string
+	fields Fields
+}
+
+func (l *memLogger) add(level Level, msg string, f Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, memEntry{level, msg, f})
+}
+func (l *memLogger) Debug(msg string, f Fields) { l.add(LevelDebug, msg, f) }
+func (l *memLogger) Info(msg string, f Fields)  { l.add(LevelInfo, msg, f) }
+func (l *memLogger) Warn(msg string, f Fields)  { l.add(LevelWarn, msg, f) }
+func (l *memLogger) Error(msg string, f Fields) { l.add(LevelError, msg, f) }
+
+// find returns the entries logged with msg.
+func (l *memLogger) find(msg string) []memEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []memEntry
+	for _, e := range l.entries {
+		if e.msg == msg {
+			out = append(out, e)
+		}
+	}
+	return out
+}
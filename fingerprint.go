@@ -0,0 +1,54 @@
+package sqlwrapper
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/syhlion/sqlwrapper/fingerprint"
+)
+
+// maxCachedFingerprints bounds the cache so that applications building SQL
+// with inlined literals cannot grow it without limit.
+const maxCachedFingerprints = 4096
+
+type fingerprintEntry struct {
+	digest     string
+	normalized string
+}
+
+// queryKey keys the caches of parsed queries, which are shared by every DB
+// of the process whatever its dialect.
+type queryKey struct {
+	dialect fingerprint.Dialect
+	query   string
+}
+
+var fingerprints struct {
+	m sync.Map
+	n atomic.Int64
+}
+
+// lexer returns the fingerprint dialect matching d.
+func (d Dialect) lexer() fingerprint.Dialect {
+	if d == DialectMySQL {
+		return fingerprint.MySQL
+	}
+	return fingerprint.Standard
+}
+
+// fingerprintOf returns the digest and normalized text of query, from the
+// cache when possible.
+func fingerprintOf(d Dialect, query string) (string, string) {
+	k := queryKey{d.lexer(), query}
+	if v, ok := fingerprints.m.Load(k); ok {
+		fe := v.(*fingerprintEntry)
+		return fe.digest, fe.normalized
+	}
+	digest, normalized := k.dialect.Of(query)
+	if fingerprints.n.Load() < maxCachedFingerprints {
+		if _, loaded := fingerprints.m.LoadOrStore(k, &fingerprintEntry{digest, normalized}); !loaded {
+			fingerprints.n.Add(1)
+		}
+	}
+	return digest, normalized
+}
@@ -0,0 +1,157 @@
+package fingerprint
+
+import "strings"
+
+// Columns returns, for each argument of query, the lower-cased name of the
+// column it is bound to, or "" when that cannot be told. Columns are found
+// from INSERT column lists and from comparisons and assignments such as
+// "col = ?", "col LIKE ?" and "col IN (?, ?)". Placeholders are ? or $n.
+func Columns(query string) []string {
+	return Standard.Columns(query)
+}
+
+// Columns is Columns in dialect d.
+func (d Dialect) Columns(query string) []string {
+	toks := d.lex(query)
+	var cols []string
+	bind := func(pos int, col string) {
+		if pos < 0 || col == "" {
+			return
+		}
+		for len(cols) <= pos {
+			cols = append(cols, "")
+		}
+		if cols[pos] == "" {
+			cols[pos] = col
+		}
+	}
+	inserted := insertColumns(toks)
+	next := 0
+	for i, t := range toks {
+		if t.kind != kParam {
+			continue
+		}
+		pos := next
+		if t.n > 0 {
+			pos = t.n - 1
+		} else {
+			next++
+		}
+		if col, ok := inserted[i]; ok {
+			bind(pos, col)
+			continue
+		}
+		bind(pos, comparedColumn(toks, i))
+	}
+	for len(cols) < next {
+		cols = append(cols, "")
+	}
+	return cols
+}
+
+// insertColumns maps the index of each placeholder standing alone in the
+// VALUES tuples of an INSERT or REPLACE to the column it fills.
+func insertColumns(toks []token) map[int]string {
+	if len(toks) == 0 || !toks[0].is(kWord, "insert") && !toks[0].is(kWord, "replace") {
+		return nil
+	}
+	open := -1
+	for i, t := range toks {
+		if t.is(kPunct, "(") {
+			open = i
+			break
+		}
+		if t.is(kWord, "values") || t.is(kWord, "value") || t.is(kWord, "select") || t.is(kWord, "set") {
+			return nil
+		}
+	}
+	if open < 0 {
+		return nil
+	}
+	end := matchParen(toks, open)
+	if end < 0 || end+1 >= len(toks) || !toks[end+1].is(kWord, "values") && !toks[end+1].is(kWord, "value") {
+		return nil
+	}
+	var names []string
+	for i := open + 1; i < end; i++ {
+		switch t := toks[i]; {
+		case t.kind == kWord || t.kind == kIdent:
+			names = append(names, identName(t))
+		case !t.is(kPunct, ","):
+			return nil
+		}
+	}
+	m := make(map[int]string)
+	for i := end + 2; i < len(toks) && toks[i].is(kPunct, "("); {
+		closing := matchParen(toks, i)
+		if closing < 0 {
+			break
+		}
+		for k, start := 0, i+1; start < closing; k++ {
+			stop := elementEnd(toks, start, closing)
+			if stop == start+1 && toks[start].kind == kParam && k < len(names) {
+				m[start] = names[k]
+			}
+			start = stop + 1
+		}
+		if closing+2 >= len(toks) || !toks[closing+1].is(kPunct, ",") {
+			break
+		}
+		i = closing + 2
+	}
+	return m
+}
+
+// comparedColumn returns the column compared with or assigned the
+// placeholder at i, or "".
+func comparedColumn(toks []token, i int) string {
+	j := i - 1
+	// Step out of an IN list: col IN (?, ?, ?).
+	for j >= 0 && (toks[j].kind == kParam || toks[j].kind == kLiteral || toks[j].is(kPunct, ",")) {
+		j--
+	}
+	if j >= 1 && toks[j].is(kPunct, "(") && toks[j-1].is(kWord, "in") {
+		j -= 2
+		if j >= 0 && toks[j].is(kWord, "not") {
+			j--
+		}
+	} else {
+		j = i - 1
+		if j < 0 || !isComparison(toks[j]) {
+			return ""
+		}
+		j--
+		if j >= 0 && toks[j].is(kWord, "not") {
+			j--
+		}
+	}
+	if j < 0 || toks[j].kind != kWord && toks[j].kind != kIdent {
+		return ""
+	}
+	return identName(toks[j])
+}
+
+func isComparison(t token) bool {
+	switch {
+	case t.kind == kPunct:
+		switch t.text {
+		case "=", "==", "!=", "<>", "<", ">", "<=", ">=", "<=>":
+			return true
+		}
+	case t.kind == kWord:
+		switch t.text {
+		case "like", "ilike":
+			return true
+		}
+	}
+	return false
+}
+
+// identName strips the quotes of a quoted identifier and lower-cases it.
+func identName(t token) string {
+	name := t.text
+	if t.kind == kIdent && len(name) >= 2 {
+		name = name[1 : len(name)-1]
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,37 @@
+package fingerprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    []string
+	}{
+		{"comparisons", MySQL, "select * from t where email = ? and age > ? and name like ?", []string{"email", "age", "name"}},
+		{"qualified and quoted", MySQL, "select * from t where t.Email = ? and `Card` <> ?", []string{"email", "card"}},
+		{"double quoted identifier", Standard, `select * from t where "Email" = $1`, []string{"email"}},
+		{"in list", MySQL, "select * from t where id in (?, ?) and email not in (?)", []string{"id", "id", "email"}},
+		{"not like", Standard, "select * from t where name not ilike $1", []string{"name"}},
+		{"numbered", Standard, "select * from t where b = $2 and a = $1", []string{"a", "b"}},
+		{"update", MySQL, "update t set email = ?, age = ? where id = ?", []string{"email", "age", "id"}},
+		{"insert", MySQL, "insert into t (id, email) values (?, ?), (?, ?)", []string{"id", "email", "id", "email"}},
+		{"insert expression", MySQL, "insert into t (id, email) values (?, lower(?))", []string{"id", ""}},
+		{"unknown", MySQL, "select * from t where a = ? + ?", []string{"a", ""}},
+		{"function argument", MySQL, "select f(?)", []string{""}},
+		{"mysql string before placeholder", MySQL, `select * from t where a = "x" and email = ?`, []string{"email"}},
+		{"hash operator", Standard, "UPDATE t SET data = data #- '{a}', password = $1 WHERE id = $2", []string{"password", "id"}},
+		{"no placeholders", MySQL, "select 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Columns(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Columns(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+// Package fingerprint normalizes SQL so that queries differing only in
+// literal values, comments, whitespace or case, or in the length of an IN
+// list or VALUES list, map to the same text and digest.
+//
+// The lexer understands MySQL and PostgreSQL quoting: single and double
+// quotes with doubled-quote escapes, backslash escapes, backtick
+// identifiers, and $$ or $tag$ dollar quoting. Where the two disagree the
+// Dialect decides.
+package fingerprint
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Dialect selects the quoting rules that differ between databases.
+type Dialect int
+
+const (
+	// Standard follows standard SQL, as PostgreSQL and SQLite do: "..." is
+	// an identifier, backslash is only an escape in E'...' strings and #
+	// is an operator.
+	Standard Dialect = iota
+	// MySQL treats "..." as a string, backslash as an escape in every
+	// string and # as the start of a comment.
+	MySQL
+)
+
+// Of returns the digest and the normalized text of query in the Standard
+// dialect.
+func Of(query string) (digest, normalized string) {
+	return Standard.Of(query)
+}
+
+// Normalize normalizes query in the Standard dialect.
+func Normalize(query string) string {
+	return Standard.Normalize(query)
+}
+
+// Of returns the digest and the normalized text of query.
+func (d Dialect) Of(query string) (digest, normalized string) {
+	normalized = d.Normalize(query)
+	return Digest(normalized), normalized
+}
+
+// Digest returns a 16 character hex digest of an already normalized query.
+func Digest(normalized string) string {
+	h := fnv.New64a()
+	h.Write([]byte(normalized))
+	d := strconv.FormatUint(h.Sum64(), 16)
+	if len(d) < 16 {
+		d = strings.Repeat("0", 16-len(d)) + d
+	}
+	return d
+}
+
+// Normalize strips comments, replaces literals and placeholders with ?,
+// collapses IN lists and VALUES lists to their first element, lower-cases
+// unquoted words and separates tokens with single spaces.
+func (d Dialect) Normalize(query string) string {
+	return render(collapse(d.lex(query)))
+}
+
+type kind int
+
+const (
+	kWord kind = iota
+	kIdent
+	kLiteral
+	kParam
+	kPunct
+)
+
+type token struct {
+	kind kind
+	text string
+	// n is the number of a $n placeholder, 0 for ?.
+	n int
+}
+
+func (t token) is(kind kind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// lex splits query into tokens. Comments are dropped, literals and
+// placeholders become a single "?" token and unquoted words are lower-cased.
+// A sign in front of a number is part of the literal unless it follows an
+// operand, so "x = -1" and "x = 1" normalize alike.
+func (d Dialect) lex(q string) []token {
+	var toks []token
+	lit := token{kind: kLiteral, text: "?"}
+	param := token{kind: kParam, text: "?"}
+	i := 0
+	for i < len(q) {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && i+1 < len(q) && q[i+1] == '-', c == '#' && d == MySQL:
+			i = skipLine(q, i)
+		case c == '/' && i+1 < len(q) && q[i+1] == '*':
+			i = skipBlockComment(q, i)
+		case c == '\'':
+			i = skipQuoted(q, i, '\'', d == MySQL)
+			toks = append(toks, lit)
+		case c == '"' && d == MySQL:
+			i = skipQuoted(q, i, '"', true)
+			toks = append(toks, lit)
+		case c == '"':
+			j := skipQuoted(q, i, '"', false)
+			toks = append(toks, token{kind: kIdent, text: q[i:j]})
+			i = j
+		case c == '`':
+			j := skipQuoted(q, i, '`', false)
+			toks = append(toks, token{kind: kIdent, text: q[i:j]})
+			i = j
+		case c == '$':
+			if j, ok := skipDollar(q, i); ok {
+				if n, err := strconv.Atoi(q[i+1 : j]); err == nil {
+					toks = append(toks, token{kind: kParam, text: "?", n: n})
+				} else {
+					toks = append(toks, lit)
+				}
+				i = j
+			} else {
+				toks = append(toks, token{kind: kPunct, text: "$"})
+				i++
+			}
+		case c == '?':
+			toks = append(toks, param)
+			i++
+		case isDigit(c) || c == '.' && i+1 < len(q) && isDigit(q[i+1]):
+			i = skipNumber(q, i)
+			toks = append(toks, lit)
+		case isSign(q, i) && !afterOperand(toks):
+			i = skipNumber(q, i+1)
+			toks = append(toks, lit)
+		case isWordStart(c):
+			j := i
+			for j < len(q) && isWordPart(q[j]) {
+				j++
+			}
+			// Prefixed strings: E'..', N'..', B'..', X'..' and U&'..'.
+			if j < len(q) && q[j] == '\'' && isStringPrefix(q[i:j]) {
+				i = skipQuoted(q, j, '\'', d == MySQL || strings.EqualFold(q[i:j], "e"))
+				toks = append(toks, lit)
+				continue
+			}
+			if j+1 < len(q) && q[j] == '&' && q[j+1] == '\'' && strings.EqualFold(q[i:j], "u") {
+				i = skipQuoted(q, j+1, '\'', false)
+				toks = append(toks, lit)
+				continue
+			}
+			toks = append(toks, token{kind: kWord, text: strings.ToLower(q[i:j])})
+			i = j
+		default:
+			j := i + 1
+			for j < len(q) && isOperator(c) && isOperator(q[j]) && !isSign(q, j) {
+				j++
+			}
+			toks = append(toks, token{kind: kPunct, text: q[i:j]})
+			i = j
+		}
+	}
+	return toks
+}
+
+func skipLine(q string, i int) int {
+	if n := strings.IndexByte(q[i:], '\n'); n >= 0 {
+		return i + n + 1
+	}
+	return len(q)
+}
+
+// skipBlockComment handles PostgreSQL's nested comments as well.
+func skipBlockComment(q string, i int) int {
+	depth := 0
+	for i < len(q) {
+		switch {
+		case q[i] == '/' && i+1 < len(q) && q[i+1] == '*':
+			depth++
+			i += 2
+		case q[i] == '*' && i+1 < len(q) && q[i+1] == '/':
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(q)
+}
+
+// skipQuoted returns the index just past the quoted section starting at i.
+// A doubled quote is an escaped quote; backslash escapes are honoured when
+// backslash is set.
+func skipQuoted(q string, i int, quote byte, backslash bool) int {
+	i++
+	for i < len(q) {
+		switch q[i] {
+		case '\\':
+			if backslash {
+				i += 2
+				continue
+			}
+		case quote:
+			if i+1 < len(q) && q[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return len(q)
+}
+
+// skipDollar handles $1 style placeholders and $$ or $tag$ quoted strings.
+func skipDollar(q string, i int) (int, bool) {
+	j := i + 1
+	if j < len(q) && isDigit(q[j]) {
+		for j < len(q) && isDigit(q[j]) {
+			j++
+		}
+		return j, true
+	}
+	for j < len(q) && isWordPart(q[j]) && q[j] != '$' {
+		j++
+	}
+	if j >= len(q) || q[j] != '$' {
+		return i, false
+	}
+	tag := q[i : j+1]
+	if n := strings.Index(q[j+1:], tag); n >= 0 {
+		return j + 1 + n + len(tag), true
+	}
+	return len(q), true
+}
+
+func skipNumber(q string, i int) int {
+	if q[i] == '0' && i+1 < len(q) && (q[i+1] == 'x' || q[i+1] == 'X') {
+		i += 2
+		for i < len(q) && isHex(q[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(q) && (isDigit(q[i]) || q[i] == '.') {
+		i++
+	}
+	if i < len(q) && (q[i] == 'e' || q[i] == 'E') {
+		j := i + 1
+		if j < len(q) && (q[j] == '+' || q[j] == '-') {
+			j++
+		}
+		if j < len(q) && isDigit(q[j]) {
+			i = j
+			for i < len(q) && isDigit(q[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// isSign reports whether q[i] is a + or - starting a number, as in "a=-1".
+func isSign(q string, i int) bool {
+	return (q[i] == '-' || q[i] == '+') && i+1 < len(q) && (isDigit(q[i+1]) || q[i+1] == '.')
+}
+
+// afterOperand reports whether the last token ends an operand, making a
+// following sign a binary operator as in "a - 1" or "f(x) - 1".
+func afterOperand(toks []token) bool {
+	if len(toks) == 0 {
+		return false
+	}
+	t := toks[len(toks)-1]
+	switch t.kind {
+	case kIdent, kLiteral, kParam:
+		return true
+	case kPunct:
+		return t.text == ")"
+	}
+	return !keywords[t.text]
+}
+
+// keywords are the words a signed number commonly follows.
+var keywords = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true,
+	"on": true, "having": true, "when": true, "then": true, "else": true,
+	"between": true, "like": true, "is": true, "in": true, "values": true,
+	"value": true, "set": true, "limit": true, "offset": true, "by": true,
+	"return": true, "returning": true, "case": true,
+}
+
+func isStringPrefix(p string) bool {
+	switch strings.ToLower(p) {
+	case "e", "n", "b", "x":
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHex(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
+
+func isOperator(c byte) bool {
+	return strings.IndexByte("<>=!|&+-*/%^~:@#", c) >= 0
+}
+
+// collapse reduces IN lists and VALUES lists to their first element when
+// the elements contain nothing but literals.
+func collapse(toks []token) []token {
+	out := make([]token, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		out = append(out, t)
+		if t.kind != kWord || i+1 >= len(toks) || !toks[i+1].is(kPunct, "(") {
+			continue
+		}
+		switch t.text {
+		case "in":
+			end := matchParen(toks, i+1)
+			if end < 0 || !literalOnly(toks[i+2:end]) {
+				continue
+			}
+			first := elementEnd(toks, i+2, end)
+			out = append(out, toks[i+1:first]...)
+			out = append(out, toks[end])
+			i = end
+		case "values", "value":
+			// Keep the first tuple, skip ", (...)" repeats.
+			end := matchParen(toks, i+1)
+			if end < 0 {
+				continue
+			}
+			out = append(out, toks[i+1:end+1]...)
+			i = end
+			for i+2 < len(toks) && toks[i+1].is(kPunct, ",") && toks[i+2].is(kPunct, "(") {
+				next := matchParen(toks, i+2)
+				if next < 0 {
+					break
+				}
+				i = next
+			}
+		}
+	}
+	return out
+}
+
+// matchParen returns the index of the parenthesis closing the one at open.
+func matchParen(toks []token, open int) int {
+	depth := 0
+	for i := open; i < len(toks); i++ {
+		switch {
+		case toks[i].is(kPunct, "("):
+			depth++
+		case toks[i].is(kPunct, ")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// elementEnd returns the index of the first top-level comma in
+// toks[start:end], or end.
+func elementEnd(toks []token, start, end int) int {
+	depth := 0
+	for i := start; i < end; i++ {
+		switch {
+		case toks[i].is(kPunct, "("):
+			depth++
+		case toks[i].is(kPunct, ")"):
+			depth--
+		case depth == 0 && toks[i].is(kPunct, ","):
+			return i
+		}
+	}
+	return end
+}
+
+func literalOnly(toks []token) bool {
+	if len(toks) == 0 {
+		return false
+	}
+	for _, t := range toks {
+		switch {
+		case t.kind == kLiteral, t.kind == kParam:
+		case t.is(kPunct, ","), t.is(kPunct, "("), t.is(kPunct, ")"), t.is(kPunct, "-"):
+		case t.kind == kWord && (t.text == "null" || t.text == "true" || t.text == "false"):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func render(toks []token) string {
+	var b strings.Builder
+	for i, t := range toks {
+		if i > 0 && space(toks[i-1], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+func space(prev, t token) bool {
+	switch {
+	case t.is(kPunct, ","), t.is(kPunct, ")"), t.is(kPunct, "."), t.is(kPunct, ";"):
+		return false
+	case prev.is(kPunct, "("), prev.is(kPunct, "."):
+		return false
+	}
+	return true
+}
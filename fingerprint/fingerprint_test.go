@@ -0,0 +1,81 @@
+package fingerprint
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{"case and space", Standard, "SELECT  *\n\tFROM t WHERE id=1", "select * from t where id = ?"},
+		{"single quotes", Standard, "select * from t where a = 'x' and b = 'it''s'", "select * from t where a = ? and b = ?"},
+		{"standard backslash", Standard, `select * from t where p = 'C:\' and id = 5`, "select * from t where p = ? and id = ?"},
+		{"mysql backslash", MySQL, `select * from t where p = 'a\'b' and id = 5`, "select * from t where p = ? and id = ?"},
+		{"standard double quotes", Standard, `select "Name" from t where "id" = 1`, `select "Name" from t where "id" = ?`},
+		{"mysql double quotes", MySQL, `select * from t where name = "bob" and x = "a\"b"`, "select * from t where name = ? and x = ?"},
+		{"mysql doubled double quotes", MySQL, `select * from t where name = "say ""hi"""`, "select * from t where name = ?"},
+		{"backticks", MySQL, "select `Order` from `t` where `id` = 1", "select `Order` from `t` where `id` = ?"},
+		{"e string", Standard, `select * from t where a = E'it\'s' and b = 2`, "select * from t where a = ? and b = ?"},
+		{"prefixed strings", Standard, "select N'x', B'101', X'ff', U&'d\\0061'", "select ?, ?, ?, ?"},
+		{"dollar quotes", Standard, "select $$it's$$, $fn$a $$ b$fn$, 1", "select ?, ?, ?"},
+		{"dollar placeholders", Standard, "select * from t where a = $1 and b = $2", "select * from t where a = ? and b = ?"},
+		{"question placeholders", MySQL, "select * from t where a = ? and b = ?", "select * from t where a = ? and b = ?"},
+		{"numbers", Standard, "select 1.5, .5, 1e10, 2E-3, 0xFF", "select ?, ?, ?, ?, ?"},
+		{"unary minus", Standard, "select * from t where x = -1 and y = +2.5", "select * from t where x = ? and y = ?"},
+		{"unary minus after keyword", Standard, "select -1, 2 where -3 < x", "select ?, ? where ? < x"},
+		{"binary minus", Standard, "select a - 1, a-1, f(a) - 1, 2 - 1 from t", "select a - ?, a - ?, f (a) - ?, ? - ? from t"},
+		{"line comments", MySQL, "select 1 -- one\n# two\nfrom t", "select ? from t"},
+		{"standard hash operators", Standard, "SELECT data #>> '{a,b}', data #- '{c}' FROM t WHERE id = $1", "select data #>> ?, data #- ? from t where id = ?"},
+		{"standard hash", Standard, "select a # b from t where id = 1", "select a # b from t where id = ?"},
+		{"block comments", Standard, "select /* a /* nested */ b */ 1 from t", "select ? from t"},
+		{"in list", Standard, "select * from t where id in (1, 2, 3)", "select * from t where id in (?)"},
+		{"in list placeholders", MySQL, "select * from t where id in (?, ?, ?) and b = ?", "select * from t where id in (?) and b = ?"},
+		{"in list negative", Standard, "select * from t where id in (-1, -2)", "select * from t where id in (?)"},
+		{"in subquery", Standard, "select * from t where id in (select id from u where x = 1)", "select * from t where id in (select id from u where x = ?)"},
+		{"values", MySQL, "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')", "insert into t (a, b) values (?, ?)"},
+		{"value", MySQL, "insert into t (a) value (1), (2)", "insert into t (a) value (?)"},
+		{"values placeholders", Standard, "insert into t (a, b) values ($1, $2), ($3, $4)", "insert into t (a, b) values (?, ?)"},
+		{"operators", Standard, "select * from t where a>=1 and b<>2 and c::text = 'x'", "select * from t where a >= ? and b <> ? and c :: text = ?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Normalize(tt.query); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOf(t *testing.T) {
+	same := [][2]string{
+		{"select * from t where id in (1, 2)", "SELECT * FROM t WHERE id IN (7)"},
+		{"select * from t where x = -1", "select * from t where x = 1"},
+		{`select * from t where name = "bob"`, `select * from t where name = "alice"`},
+	}
+	for _, q := range same {
+		d1, _ := MySQL.Of(q[0])
+		d2, _ := MySQL.Of(q[1])
+		if d1 != d2 {
+			t.Errorf("%q and %q have different digests", q[0], q[1])
+		}
+	}
+	differ := [][2]string{
+		{`select * from t where p = 'C:\' and id = 5`, `select * from t where p = 'C:\' and name = 5`},
+		{"select a from t", "select b from t"},
+		{"SELECT data #>> '{a,b}' FROM t WHERE id = $1", "SELECT data #>> '{a,b}' FROM u WHERE id = $1"},
+		{"SELECT data #- '{a}' FROM t WHERE id = $1", "SELECT data #- '{a}' FROM t WHERE x = $1"},
+		{"SELECT a # b FROM t WHERE id = $1", "SELECT a # c FROM u WHERE x = 1"},
+	}
+	for _, q := range differ {
+		d1, _ := Of(q[0])
+		d2, _ := Of(q[1])
+		if d1 == d2 {
+			t.Errorf("%q and %q have the same digest", q[0], q[1])
+		}
+	}
+	if d, _ := Of("select 1"); len(d) != 16 {
+		t.Errorf("digest %q is not 16 characters long", d)
+	}
+}
@@ -0,0 +1,369 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Op is the kind of operation an Event describes.
+type Op string
+
+const (
+	OpExec     Op = "exec"
+	OpQuery    Op = "query"
+	OpQueryRow Op = "query row"
+	OpPrepare  Op = "prepare"
+	OpPing     Op = "ping"
+	OpBegin    Op = "begin"
+	OpCommit   Op = "commit"
+	OpRollback Op = "rollback"
+	// Savepoint operations of a Tx.
+	OpSavepoint  Op = "savepoint"
+	OpRollbackTo Op = "rollback to"
+	OpRelease    Op = "release"
+	OpClose      Op = "close"
+	// OpConn is taking a connection from the pool for a Conn.
+	OpConn Op = "conn"
+	// OpRaw covers Conn.Raw.
+	OpRaw Op = "raw"
+	// OpFetch covers reading a result set, from the query until Close.
+	OpFetch Op = "fetch"
+)
+
+// Origin is the wrapper type an Event was emitted from.
+type Origin string
+
+const (
+	OriginDB   Origin = "db"
+	OriginTx   Origin = "tx"
+	OriginStmt Origin = "stmt"
+	OriginConn Origin = "conn"
+
+	// Origins of events emitted by a driver registered with Register or
+	// WrapConnector.
+	OriginDriverConn Origin = "driver conn"
+	OriginDriverStmt Origin = "driver stmt"
+	OriginDriverTx   Origin = "driver tx"
+)
+
+// Event describes a single operation. Before hooks see it with only the
+// request fields filled in; After hooks also see Duration, Err and the
+// outcome fields. An Err of driver.ErrSkip means the driver declined the
+// call and database/sql retries it through a prepared statement, which
+// produces its own events.
+type Event struct {
+	Op     Op
+	Origin Origin
+	Query  string
+	Args   []interface{}
+	Start  time.Time
+	// Fingerprint and Normalized identify the shape of Query, see the
+	// fingerprint package. Both are empty for events without SQL.
+	Fingerprint string
+	Normalized  string
+
+	Duration time.Duration
+	Err      error
+	ErrClass ErrorClass
+	// RowsAffected and LastInsertID are -1 when unknown or unsupported by
+	// the driver.
+	RowsAffected int64
+	LastInsertID int64
+
+	// Fetch events only: rows read, time from the query to the first row,
+	// and how many times the result moved on to a further result set.
+	RowsRead   int64
+	FirstRow   time.Duration
+	ResultSets int
+
+	// ConnID identifies the Conn the operation ran on, directly or through
+	// a transaction or statement made from it. It is 0 outside of a Conn.
+	ConnID int64
+	// TxID identifies the transaction the operation belongs to. It is 0
+	// outside of a transaction.
+	TxID int64
+	// TxSummary is set on the commit and rollback events of a Tx.
+	TxSummary *TxSummary
+
+	// tx is the transaction the operation belongs to, if any.
+	tx *Tx
+	// rawQuery is Query as the caller gave it, before hooks such as the
+	// trace comment rewrote it.
+	rawQuery string
+}
+
+// Name is the log message of the event, e.g. "tx exec".
+func (e *Event) Name() string {
+	return string(e.Origin) + " " + string(e.Op)
+}
+
+// Failed reports whether the operation returned a real error. sql.ErrNoRows
+// and driver.ErrSkip are not failures.
+func (e *Event) Failed() bool {
+	return e.Err != nil && e.Err != driver.ErrSkip && e.ErrClass != ErrClassNoRows
+}
+
+func newEvent(origin Origin, op Op, query string, args []interface{}) *Event {
+	e := &Event{
+		Op:           op,
+		Origin:       origin,
+		Query:        query,
+		Args:         args,
+		RowsAffected: -1,
+		LastInsertID: -1,
+	}
+	return e
+}
+
+// result is the method set shared by sql.Result and driver.Result.
+type result interface {
+	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
+}
+
+// setResult records the outcome of an exec. database/sql passes every call
+// on to the driver, so this assumes, as holds for the common drivers whose
+// results are plain values, that reading them again is cheap and has no
+// side effects.
+func (e *Event) setResult(r result) {
+	if n, err := r.RowsAffected(); err == nil {
+		e.RowsAffected = n
+	}
+	if id, err := r.LastInsertId(); err == nil {
+		e.LastInsertID = id
+	}
+}
+
+// tooManyRows reports whether an UPDATE or DELETE went over max.
+func (e *Event) tooManyRows(max int64) bool {
+	if max <= 0 || e.Op != OpExec || e.RowsAffected <= max {
+		return false
+	}
+	switch statementVerb(e.Query) {
+	case "update", "delete":
+		return true
+	}
+	return false
+}
+
+// Hook intercepts operations. Before runs ahead of the call and may return a
+// derived context, which is passed on to the driver and to After.
+type Hook interface {
+	Before(ctx context.Context, e *Event) context.Context
+	After(ctx context.Context, e *Event)
+}
+
+// WithHooks appends hooks to the chain. Hooks run in the order given, after
+// the built-in logging hook.
+func WithHooks(hooks ...Hook) Option {
+	return func(c *Config) {
+		c.Hooks = append(c.Hooks, hooks...)
+	}
+}
+
+// core is the state shared by a DB and everything created from it.
+type core struct {
+	cfg     *Config
+	hooks   []Hook
+	stats   *statsHook
+	sampler *sampler
+	live    atomic.Pointer[settings]
+	// openTxs holds the open transactions while a watchdog runs.
+	openTxs sync.Map
+	// leaks is set by WithLeakDetector.
+	leaks *leaks
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newCore(cfg *Config) *core {
+	c := &core{
+		cfg:     cfg,
+		stats:   newStatsHook(cfg.StatsLimit),
+		sampler: newSampler(cfg.Sampling),
+		done:    make(chan struct{}),
+	}
+	if cfg.LeakDetector != nil {
+		c.leaks = &leaks{cfg: *cfg.LeakDetector}
+	}
+	c.live.Store(&settings{debug: cfg.Debug, slow: cfg.SlowThreshold, level: cfg.LogLevel})
+	lh := &logHook{
+		core:       c,
+		thresholds: newThresholds(cfg.Thresholds),
+		redact:     newRedactor(cfg.Redaction, cfg.Dialect),
+		sampler:    c.sampler,
+	}
+	c.hooks = append(c.hooks, lh, c.stats)
+	if cfg.Metrics != nil {
+		c.hooks = append(c.hooks, &metricsHook{m: cfg.Metrics, name: cfg.Name})
+	}
+	if cfg.Tracer != nil {
+		c.hooks = append(c.hooks, &traceHook{tracer: cfg.Tracer, comment: cfg.TraceComment})
+	}
+	c.hooks = append(c.hooks, cfg.Hooks...)
+	return c
+}
+
+// start launches the background loops the configuration asks for.
+func (c *core) start() {
+	if c.cfg.StatsReportInterval > 0 {
+		c.every(c.cfg.StatsReportInterval, func() { c.stats.report(c) })
+	}
+	if c.sampler != nil {
+		c.every(c.sampler.interval, func() { c.sampler.report(c) })
+	}
+	if w := c.cfg.TxWatchdog; w != nil {
+		c.every(w.interval(), c.watch)
+	}
+	if l := c.leaks; l != nil && l.cfg.MaxAge > 0 {
+		c.every(l.interval(), func() { l.check(c) })
+	}
+}
+
+// every runs fn each interval until close.
+func (c *core) every(interval time.Duration, fn func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// close stops the background loops and waits for them to return.
+func (c *core) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.wg.Wait()
+}
+
+func (c *core) before(ctx context.Context, e *Event) context.Context {
+	if e.Start.IsZero() {
+		e.Start = time.Now()
+	}
+	if e.Query != "" && e.Fingerprint == "" {
+		e.rawQuery = e.Query
+		e.Fingerprint, e.Normalized = fingerprintOf(c.cfg.Dialect, e.Query)
+	}
+	for _, h := range c.hooks {
+		if nctx := h.Before(ctx, e); nctx != nil {
+			ctx = nctx
+		}
+	}
+	return ctx
+}
+
+func (c *core) after(ctx context.Context, e *Event) {
+	e.Duration = time.Since(e.Start)
+	if e.Err != nil && e.ErrClass == ErrClassNone {
+		e.ErrClass = ClassifyError(e.Err)
+	}
+	if e.tx != nil && e.Err != driver.ErrSkip {
+		e.tx.track(e)
+	}
+	for _, h := range c.hooks {
+		h.After(ctx, e)
+	}
+}
+
+// logHook is the built-in debug and slow query logger.
+type logHook struct {
+	core       *core
+	thresholds *thresholds
+	redact     *redactor
+	sampler    *sampler
+}
+
+func (h *logHook) Before(ctx context.Context, e *Event) context.Context {
+	return ctx
+}
+
+func (h *logHook) After(ctx context.Context, e *Event) {
+	if e.Err == driver.ErrSkip {
+		return
+	}
+	cfg, live := h.core.cfg, h.core.live.Load()
+	failed := e.Failed()
+	tooMany := e.tooManyRows(cfg.MaxAffectedRows)
+	threshold := h.thresholds.of(ctx, e, live.slow)
+	took := e.Duration
+	if e.TxSummary != nil {
+		took = e.TxSummary.Lifetime
+	}
+	slow := threshold != ThresholdDisabled && took >= threshold
+	if !failed && !tooMany {
+		if !slow && !live.debug {
+			return
+		}
+		if slow && !h.sampler.keepSlow(e) || !slow && !h.sampler.keepDebug() {
+			return
+		}
+	}
+	f := Fields{
+		"use-time": e.Duration.String(),
+		"ip":       externalIP(),
+		"name":     cfg.Name,
+	}
+	if e.Query != "" {
+		f["sql"] = e.Query
+		f["args"] = h.redact.args(e)
+		f["fingerprint"] = e.Fingerprint
+	}
+	if e.ConnID != 0 {
+		f["conn-id"] = e.ConnID
+	}
+	if e.TxID != 0 {
+		f["tx-id"] = e.TxID
+	}
+	if s := e.TxSummary; s != nil {
+		f["tx-lifetime"] = s.Lifetime.String()
+		f["tx-statements"] = s.Statements
+		f["tx-sql-time"] = s.SQLTime.String()
+		f["tx-idle-time"] = s.Idle.String()
+		f["isolation"] = s.Isolation.String()
+		f["read-only"] = s.ReadOnly
+	}
+	if e.Op == OpExec && e.RowsAffected >= 0 {
+		f["rows-affected"] = e.RowsAffected
+	}
+	if e.LastInsertID > 0 {
+		f["last-insert-id"] = e.LastInsertID
+	}
+	if e.Op == OpFetch {
+		f["rows"] = e.RowsRead
+		f["first-row-time"] = e.FirstRow.String()
+		f["result-sets"] = e.ResultSets
+	}
+	if e.Err != nil {
+		f["error"] = e.Err.Error()
+		f["error-class"] = string(e.ErrClass)
+	}
+	if slow {
+		f["threshold"] = threshold.String()
+	}
+	switch {
+	case failed:
+		h.core.log(LevelError, e.Name(), f)
+	case tooMany:
+		f["max-affected-rows"] = cfg.MaxAffectedRows
+		h.core.log(LevelWarn, e.Name(), f)
+	case slow:
+		h.core.log(LevelWarn, e.Name(), f)
+	default:
+		h.core.log(LevelDebug, e.Name(), f)
+	}
+}
@@ -0,0 +1,254 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeakDetector tracks the Rows, Stmt, Tx and Conn values of a DB until they
+// are closed, remembering where each was created.
+type LeakDetector struct {
+	// MaxAge reports resources still open after this long. Zero only
+	// reports resources the garbage collector finds open.
+	MaxAge time.Duration
+	// Interval is how often open resources are checked against MaxAge. It
+	// defaults to a quarter of MaxAge.
+	Interval time.Duration
+}
+
+// WithLeakDetector turns on leak detection. Recording a stack trace for
+// every resource has a cost, so it is best kept to tests and diagnosis.
+func WithLeakDetector(l LeakDetector) Option {
+	return func(c *Config) {
+		c.LeakDetector = &l
+	}
+}
+
+func (l *LeakDetector) validate() error {
+	if l.MaxAge < 0 || l.Interval < 0 {
+		return errors.New("sqlwrapper: leak detector durations must not be negative")
+	}
+	return nil
+}
+
+// OpenResource describes a resource that has not been closed yet.
+type OpenResource struct {
+	// Kind is "rows", "stmt", "tx" or "conn".
+	Kind    string
+	Query   string
+	TxID    int64
+	ConnID  int64
+	Created time.Time
+	Age     time.Duration
+	// Stack is where the resource was created, starting from the caller of
+	// sqlwrapper.
+	Stack string
+}
+
+// TB is the part of testing.TB CheckLeaks needs.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// pkgPath is the package whose innermost frames are left out of recorded
+// stacks.
+var pkgPath = reflect.TypeOf(core{}).PkgPath()
+
+type resource struct {
+	leaks   *leaks
+	kind    string
+	query   string
+	txID    int64
+	connID  int64
+	created time.Time
+	pcs     []uintptr
+	// done is closed when database/sql closes the resource by itself, as
+	// it does when the context of a query or transaction is canceled.
+	done <-chan struct{}
+	// parent closes the resource along with itself, as a Tx does with its
+	// statements.
+	parent *resource
+
+	closed   atomic.Bool
+	reported atomic.Bool
+}
+
+func (r *resource) close() {
+	if r == nil {
+		return
+	}
+	r.closed.Store(true)
+	r.leaks.m.Delete(r)
+}
+
+func (r *resource) open() bool {
+	if r == nil || r.closed.Load() {
+		return false
+	}
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+	return r.parent == nil || r.parent.open()
+}
+
+func (r *resource) stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(r.pcs)
+	inner := true
+	for {
+		f, more := frames.Next()
+		if inner && (!strings.HasPrefix(f.Function, pkgPath+".") || strings.HasSuffix(f.File, "_test.go")) {
+			inner = false
+		}
+		if !inner {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+func (r *resource) snapshot(now time.Time) OpenResource {
+	return OpenResource{
+		Kind:    r.kind,
+		Query:   r.query,
+		TxID:    r.txID,
+		ConnID:  r.connID,
+		Created: r.created,
+		Age:     now.Sub(r.created),
+		Stack:   r.stack(),
+	}
+}
+
+// leaks is the registry of open resources. It only refers to resources,
+// not to the values owning them, so those can still be finalized.
+type leaks struct {
+	cfg LeakDetector
+	m   sync.Map
+}
+
+// track registers a resource created by e. It returns nil when leak
+// detection is off; every resource method accepts a nil receiver.
+func (c *core) track(kind string, e *Event, done <-chan struct{}, parent *resource) *resource {
+	if c.leaks == nil {
+		return nil
+	}
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	r := &resource{
+		leaks:   c.leaks,
+		kind:    kind,
+		query:   e.Query,
+		txID:    e.TxID,
+		connID:  e.ConnID,
+		created: time.Now(),
+		pcs:     pcs[:n],
+		done:    done,
+		parent:  parent,
+	}
+	c.leaks.m.Store(r, struct{}{})
+	return r
+}
+
+// open returns the open resources, oldest first, and forgets those that
+// database/sql closed by itself.
+func (l *leaks) open() []*resource {
+	var out []*resource
+	l.m.Range(func(k, _ interface{}) bool {
+		r := k.(*resource)
+		if r.open() {
+			out = append(out, r)
+		} else {
+			l.m.Delete(r)
+		}
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].created.Before(out[j].created)
+	})
+	return out
+}
+
+// check reports, once each, the resources open for longer than MaxAge.
+func (l *leaks) check(c *core) {
+	now := time.Now()
+	for _, r := range l.open() {
+		if now.Sub(r.created) >= l.cfg.MaxAge && r.reported.CompareAndSwap(false, true) {
+			c.leaked(r, "resource open too long")
+		}
+	}
+}
+
+func (l *leaks) interval() time.Duration {
+	if l.cfg.Interval > 0 {
+		return l.cfg.Interval
+	}
+	if d := l.cfg.MaxAge / 4; d > minInterval {
+		return d
+	}
+	return minInterval
+}
+
+// leaked logs r as a leak.
+func (c *core) leaked(r *resource, msg string) {
+	f := Fields{
+		"ip":      externalIP(),
+		"name":    c.cfg.Name,
+		"kind":    r.kind,
+		"age":     time.Since(r.created).String(),
+		"created": r.created,
+		"stack":   r.stack(),
+	}
+	if r.query != "" {
+		f["sql"] = r.query
+	}
+	if r.txID != 0 {
+		f["tx-id"] = r.txID
+	}
+	if r.connID != 0 {
+		f["conn-id"] = r.connID
+	}
+	c.log(LevelWarn, msg, f)
+}
+
+// OpenResources returns the Rows, Stmt, Tx and Conn values of d that are
+// still open, oldest first. It returns nil without WithLeakDetector.
+func (d *DB) OpenResources() []OpenResource {
+	if d.core.leaks == nil {
+		return nil
+	}
+	now := time.Now()
+	var out []OpenResource
+	for _, r := range d.core.leaks.open() {
+		out = append(out, r.snapshot(now))
+	}
+	return out
+}
+
+// CheckLeaks fails t for every resource of d that is still open. d must
+// have been built with WithLeakDetector.
+func (d *DB) CheckLeaks(t TB) {
+	t.Helper()
+	if d.core.leaks == nil {
+		t.Errorf("sqlwrapper: CheckLeaks needs WithLeakDetector")
+		return
+	}
+	for _, r := range d.OpenResources() {
+		if r.Query != "" {
+			t.Errorf("sqlwrapper: %s not closed after %s (%s), created at:\n%s", r.Kind, r.Age, r.Query, r.Stack)
+		} else {
+			t.Errorf("sqlwrapper: %s not closed after %s, created at:\n%s", r.Kind, r.Age, r.Stack)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package sqlwrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordTB collects what CheckLeaks reports.
+type recordTB struct {
+	errs []string
+}
+
+func (r *recordTB) Helper() {}
+func (r *recordTB) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestCheckLeaks(t *testing.T) {
+	db, _ := openFake(t, false, WithLeakDetector(LeakDetector{}))
+	rows, err := db.Query("select leaked_rows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	stmt, err := db.Prepare("select leaked_stmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	closed, err := db.Query("select closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	open := db.OpenResources()
+	if len(open) != 2 || open[0].Kind != "rows" || open[1].Kind != "stmt" {
+		t.Fatalf("OpenResources() = %+v, want rows then stmt", open)
+	}
+	if !strings.Contains(open[0].Stack, "TestCheckLeaks") {
+		t.Errorf("stack does not show the caller:\n%s", open[0].Stack)
+	}
+	if strings.Contains(open[0].Stack, pkgPath+".(*DB)") {
+		t.Errorf("stack shows sqlwrapper frames:\n%s", open[0].Stack)
+	}
+
+	tb := &recordTB{}
+	db.CheckLeaks(tb)
+	if len(tb.errs) != 2 || !strings.Contains(tb.errs[0], "select leaked_rows") || !strings.Contains(tb.errs[1], "select leaked_stmt") {
+		t.Fatalf("CheckLeaks reported %q", tb.errs)
+	}
+
+	rows.Close()
+	stmt.Close()
+	tb = &recordTB{}
+	db.CheckLeaks(tb)
+	if len(tb.errs) != 0 {
+		t.Errorf("CheckLeaks reported %q after Close", tb.errs)
+	}
+}
+
+func TestLeakDetectorTinyMaxAge(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log), WithLeakDetector(LeakDetector{MaxAge: 3 * time.Nanosecond}))
+	stmt, err := db.Prepare("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(log.find("resource open too long")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("open stmt not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
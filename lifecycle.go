@@ -0,0 +1,198 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// TxSummary describes a whole transaction. It is attached to its commit and
+// rollback events.
+type TxSummary struct {
+	ID int64
+	// Lifetime runs from the start of Begin to the end of Commit or
+	// Rollback, and is what the slow threshold of those events is compared
+	// with.
+	Lifetime time.Duration
+	// Statements counts execs and queries, savepoints included.
+	Statements int64
+	// SQLTime is the time spent in statements, reading result sets
+	// included; Idle is the rest of Lifetime, spent in the caller's code.
+	SQLTime   time.Duration
+	Idle      time.Duration
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+}
+
+// txState is the bookkeeping of an open transaction.
+type txState struct {
+	begun     time.Time
+	isolation sql.IsolationLevel
+	readOnly  bool
+	// done is the Done channel of the context given to Begin. When it is
+	// closed database/sql rolls the transaction back by itself.
+	done <-chan struct{}
+
+	statements atomic.Int64
+	sqlTime    atomic.Int64
+	// warned is set once the watchdog reported the transaction.
+	warned atomic.Bool
+	// ended is set once the first Commit or Rollback returned.
+	ended atomic.Bool
+}
+
+func newTx(ctx context.Context, c *core, tx *sql.Tx, begin *Event, connID int64, opts *sql.TxOptions) *Tx {
+	t := &Tx{
+		tx:     tx,
+		core:   c,
+		id:     txIDs.Add(1),
+		connID: connID,
+	}
+	t.state.begun = begin.Start
+	t.state.done = ctx.Done()
+	if opts != nil {
+		t.state.isolation, t.state.readOnly = opts.Isolation, opts.ReadOnly
+	}
+	begin.TxID, begin.tx = t.id, t
+	if c.cfg.TxWatchdog != nil {
+		c.openTxs.Store(t, struct{}{})
+	}
+	if c.leaks != nil {
+		t.res = c.track("tx", begin, t.state.done, nil)
+		runtime.SetFinalizer(t, (*Tx).finalize)
+	}
+	return t
+}
+
+func (t *Tx) finalize() {
+	if t.res.open() {
+		t.core.leaked(t.res, "tx not closed")
+		t.res.close()
+		t.tx.Rollback()
+	}
+}
+
+// track accounts for an event of t once it is complete. Commit and
+// rollback events get the summary of the transaction.
+func (t *Tx) track(e *Event) {
+	switch e.Op {
+	case OpExec, OpQueryRow, OpSavepoint, OpRollbackTo, OpRelease:
+		t.state.statements.Add(1)
+		t.state.sqlTime.Add(int64(e.Duration))
+	case OpQuery:
+		// The fetch event that follows covers the query too.
+		t.state.statements.Add(1)
+		if e.Err != nil {
+			t.state.sqlTime.Add(int64(e.Duration))
+		}
+	case OpFetch, OpPrepare:
+		t.state.sqlTime.Add(int64(e.Duration))
+	case OpCommit, OpRollback:
+		if e.Origin == OriginTx {
+			t.core.openTxs.Delete(t)
+			e.TxSummary = t.summary(e.Start.Add(e.Duration))
+		}
+	}
+}
+
+func (t *Tx) summary(end time.Time) *TxSummary {
+	s := &TxSummary{
+		ID:         t.id,
+		Lifetime:   end.Sub(t.state.begun),
+		Statements: t.state.statements.Load(),
+		SQLTime:    time.Duration(t.state.sqlTime.Load()),
+		Isolation:  t.state.isolation,
+		ReadOnly:   t.state.readOnly,
+	}
+	if s.Idle = s.Lifetime - s.SQLTime; s.Idle < 0 {
+		s.Idle = 0
+	}
+	return s
+}
+
+// TxWatchdog reports transactions that stay open too long.
+type TxWatchdog struct {
+	// MaxLifetime is how long a transaction may stay open.
+	MaxLifetime time.Duration
+	// Interval is how often open transactions are checked. It defaults to
+	// a quarter of MaxLifetime.
+	Interval time.Duration
+	// Rollback rolls the transaction back once it is reported. Later calls
+	// on it fail with sql.ErrTxDone.
+	Rollback bool
+}
+
+// WithTxWatchdog logs a warning for every transaction open longer than
+// w.MaxLifetime, once, and rolls it back if w.Rollback is set.
+func WithTxWatchdog(w TxWatchdog) Option {
+	return func(c *Config) {
+		c.TxWatchdog = &w
+	}
+}
+
+func (w *TxWatchdog) validate() error {
+	if w.MaxLifetime <= 0 {
+		return errors.New("sqlwrapper: watchdog max lifetime must be positive")
+	}
+	if w.Interval < 0 {
+		return errors.New("sqlwrapper: watchdog interval must not be negative")
+	}
+	return nil
+}
+
+// minInterval is the shortest interval derived from another setting, so
+// that a tiny MaxLifetime cannot start a ticker with a zero interval.
+const minInterval = time.Millisecond
+
+func (w *TxWatchdog) interval() time.Duration {
+	if w.Interval > 0 {
+		return w.Interval
+	}
+	if d := w.MaxLifetime / 4; d > minInterval {
+		return d
+	}
+	return minInterval
+}
+
+// watch reports the transactions open for longer than the watchdog allows.
+func (c *core) watch() {
+	w := c.cfg.TxWatchdog
+	now := time.Now()
+	c.openTxs.Range(func(k, _ interface{}) bool {
+		t := k.(*Tx)
+		select {
+		case <-t.state.done:
+			c.openTxs.Delete(t)
+			return true
+		default:
+		}
+		s := t.summary(now)
+		if s.Lifetime < w.MaxLifetime || !t.state.warned.CompareAndSwap(false, true) {
+			return true
+		}
+		f := Fields{
+			"ip":            externalIP(),
+			"name":          c.cfg.Name,
+			"tx-id":         t.id,
+			"tx-lifetime":   s.Lifetime.String(),
+			"tx-statements": s.Statements,
+			"tx-sql-time":   s.SQLTime.String(),
+			"tx-idle-time":  s.Idle.String(),
+			"isolation":     s.Isolation.String(),
+			"read-only":     s.ReadOnly,
+			"max-lifetime":  w.MaxLifetime.String(),
+			"rollback":      w.Rollback,
+		}
+		if t.connID != 0 {
+			f["conn-id"] = t.connID
+		}
+		c.log(LevelWarn, "long transaction", f)
+		if w.Rollback {
+			t.Rollback()
+		}
+		return true
+	})
+}
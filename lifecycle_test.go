@@ -0,0 +1,81 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestTxWatchdogTinyLifetime(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log), WithTxWatchdog(TxWatchdog{MaxLifetime: 3 * time.Nanosecond}))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	deadline := time.Now().Add(time.Second)
+	for len(log.find("long transaction")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("long transaction not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// summaryHook counts the events carrying a TxSummary.
+type summaryHook struct{ n int }
+
+func (h *summaryHook) Before(ctx context.Context, e *Event) context.Context { return ctx }
+func (h *summaryHook) After(ctx context.Context, e *Event) {
+	if e.TxSummary != nil {
+		h.n++
+	}
+}
+
+func TestTxDeferredRollbackAfterCommit(t *testing.T) {
+	log := &memLogger{}
+	m := NewMetrics()
+	h := &summaryHook{}
+	db, d := openFake(t, false, WithLogger(log), WithDebug(true), WithMetrics(m), WithHooks(h))
+	func() {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	checkCalls(t, d, "begin", "commit")
+	if r := log.find("tx rollback"); len(r) != 0 {
+		t.Errorf("late rollback logged: %v", r)
+	}
+	if h.n != 1 {
+		t.Errorf("%d tx summaries, want 1", h.n)
+	}
+	m.counts.Range(func(k, _ interface{}) bool {
+		if k.(countKey).op == "tx_rollback" {
+			t.Errorf("late rollback counted: %+v", k)
+		}
+		return true
+	})
+
+	// A second Commit is still a mistake worth reporting.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	if err := tx.Commit(); err != sql.ErrTxDone {
+		t.Errorf("second Commit = %v, want sql.ErrTxDone", err)
+	}
+	if r := log.find("tx commit"); len(r) != 3 || r[2].level != LevelError {
+		t.Errorf("second Commit not logged as an error: %v", r)
+	}
+	if err := tx.Rollback(); err != sql.ErrTxDone {
+		t.Errorf("Rollback = %v, want sql.ErrTxDone", err)
+	}
+}
@@ -0,0 +1,152 @@
+package sqlwrapper
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields carries the structured data attached to a log entry.
+type Fields map[string]interface{}
+
+// Logger receives every event sqlwrapper emits.
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("sqlwrapper: unknown log level %q", s)
+}
+
+func (l Level) valid() bool {
+	return l >= LevelDebug && l <= LevelError
+}
+
+var (
+	stdOnce   sync.Once
+	stdLogger Logger
+)
+
+// defaultLogger returns the package's own logrus instance, writing JSON to
+// stdout at debug level. The logrus standard logger is never touched.
+func defaultLogger() Logger {
+	stdOnce.Do(func() {
+		l := logrus.New()
+		l.Formatter = &logrus.JSONFormatter{}
+		l.Out = os.Stdout
+		l.Level = logrus.DebugLevel
+		stdLogger = NewLogrusLogger(l)
+	})
+	return stdLogger
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+// NewLogrusLogger adapts a logrus logger or entry.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return &logrusLogger{l: l}
+}
+
+func (l *logrusLogger) Debug(msg string, fields Fields) {
+	l.l.WithFields(logrus.Fields(fields)).Debug(msg)
+}
+func (l *logrusLogger) Info(msg string, fields Fields) {
+	l.l.WithFields(logrus.Fields(fields)).Info(msg)
+}
+func (l *logrusLogger) Warn(msg string, fields Fields) {
+	l.l.WithFields(logrus.Fields(fields)).Warn(msg)
+}
+func (l *logrusLogger) Error(msg string, fields Fields) {
+	l.l.WithFields(logrus.Fields(fields)).Error(msg)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger adapts a log/slog logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func (l *slogLogger) log(level slog.Level, msg string, fields Fields) {
+	ctx := context.Background()
+	if !l.l.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
+	for k, v := range fields {
+		attrs = append(attrs, slog.Any(k, v))
+	}
+	l.l.LogAttrs(ctx, level, msg, attrs...)
+}
+func (l *slogLogger) Debug(msg string, fields Fields) {
+	l.log(slog.LevelDebug, msg, fields)
+}
+func (l *slogLogger) Info(msg string, fields Fields) {
+	l.log(slog.LevelInfo, msg, fields)
+}
+func (l *slogLogger) Warn(msg string, fields Fields) {
+	l.log(slog.LevelWarn, msg, fields)
+}
+func (l *slogLogger) Error(msg string, fields Fields) {
+	l.log(slog.LevelError, msg, fields)
+}
+
+type nopLogger struct{}
+
+// NewNopLogger returns a Logger that discards everything.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(string, Fields) {}
+func (nopLogger) Info(string, Fields)  {}
+func (nopLogger) Warn(string, Fields)  {}
+func (nopLogger) Error(string, Fields) {}
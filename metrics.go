@@ -0,0 +1,386 @@
+package sqlwrapper
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMetricsFingerprints is how many fingerprints get their own
+	// series before the rest are reported as "other".
+	DefaultMetricsFingerprints = 200
+
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricBuckets are the upper bounds, in seconds, of the latency histograms.
+var metricBuckets = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects counters and latency histograms from every DB it is
+// given to with WithMetrics, along with their sql.DBStats. It serves them
+// in Prometheus and OpenMetrics text format through Handler and as JSON
+// through expvar.
+type Metrics struct {
+	maxFingerprints int64
+	fingerprints    atomic.Int64
+
+	ops    sync.Map // opKey -> *histogram
+	counts sync.Map // countKey -> *atomic.Uint64
+	fps    sync.Map // fpKey -> *histogram
+
+	mu sync.Mutex
+	// pools holds the DBs by name; drivers and connectors, which have no
+	// pool of their own, hold their name with a nil DB.
+	pools map[string]*sql.DB
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		maxFingerprints: DefaultMetricsFingerprints,
+		pools:           make(map[string]*sql.DB),
+	}
+}
+
+// WithMetrics records every operation into m. The DBs sharing m are told
+// apart by their name, so each needs its own WithName.
+func WithMetrics(m *Metrics) Option {
+	return func(c *Config) {
+		c.Metrics = m
+	}
+}
+
+type opKey struct {
+	db, op string
+}
+
+type countKey struct {
+	db, op, outcome string
+}
+
+type fpKey struct {
+	db, fingerprint, op string
+}
+
+type histogram struct {
+	counts [len(metricBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(metricBuckets[:], s)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) count() uint64 {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+func loadHistogram(m *sync.Map, key interface{}) *histogram {
+	if v, ok := m.Load(key); ok {
+		return v.(*histogram)
+	}
+	v, _ := m.LoadOrStore(key, &histogram{})
+	return v.(*histogram)
+}
+
+func (m *Metrics) record(db string, e *Event) {
+	op := metricOp(e)
+	outcome := "ok"
+	if e.Failed() {
+		outcome = string(e.ErrClass)
+	}
+	ck := countKey{db, op, outcome}
+	c, ok := m.counts.Load(ck)
+	if !ok {
+		c, _ = m.counts.LoadOrStore(ck, new(atomic.Uint64))
+	}
+	c.(*atomic.Uint64).Add(1)
+	loadHistogram(&m.ops, opKey{db, op}).observe(e.Duration)
+	if e.Fingerprint != "" {
+		m.fingerprintHistogram(fpKey{db, e.Fingerprint, op}).observe(e.Duration)
+	}
+}
+
+func (m *Metrics) fingerprintHistogram(key fpKey) *histogram {
+	if v, ok := m.fps.Load(key); ok {
+		return v.(*histogram)
+	}
+	if m.fingerprints.Load() >= m.maxFingerprints {
+		key.fingerprint = "other"
+		return loadHistogram(&m.fps, key)
+	}
+	v, loaded := m.fps.LoadOrStore(key, &histogram{})
+	if !loaded {
+		m.fingerprints.Add(1)
+	}
+	return v.(*histogram)
+}
+
+// metricOp turns "tx commit" into "tx_commit".
+func metricOp(e *Event) string {
+	return strings.Replace(e.Name(), " ", "_", -1)
+}
+
+func (m *Metrics) addPool(name string, db *sql.DB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pools[name]; ok {
+		return errors.New("sqlwrapper: metrics already record a DB named " + strconv.Quote(name) + ", use WithName")
+	}
+	m.pools[name] = db
+	return nil
+}
+
+func (m *Metrics) removePool(name string, db *sql.DB) {
+	m.mu.Lock()
+	if m.pools[name] == db {
+		delete(m.pools, name)
+	}
+	m.mu.Unlock()
+}
+
+func (m *Metrics) poolStats() map[string]sql.DBStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]sql.DBStats, len(m.pools))
+	for name, db := range m.pools {
+		if db != nil {
+			out[name] = db.Stats()
+		}
+	}
+	return out
+}
+
+// Handler serves the metrics in OpenMetrics text format when the client
+// asks for it, and in the Prometheus text format otherwise.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", contentTypeOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", contentTypeText)
+		}
+		m.write(w, openMetrics)
+	})
+}
+
+// WriteText writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	return m.write(w, false)
+}
+
+type sample struct {
+	labels string
+	value  string
+}
+
+func (m *Metrics) write(out io.Writer, openMetrics bool) error {
+	w := bufio.NewWriter(out)
+	family := func(name, typ, help string, samples []sample) {
+		if len(samples) == 0 {
+			return
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		famName := name
+		if openMetrics && typ == "counter" {
+			famName = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", famName, help, famName, typ)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, s.labels, s.value)
+		}
+	}
+
+	var counts []sample
+	m.counts.Range(func(k, v interface{}) bool {
+		key := k.(countKey)
+		counts = append(counts, sample{
+			labels: labels("db", key.db, "op", key.op, "outcome", key.outcome),
+			value:  strconv.FormatUint(v.(*atomic.Uint64).Load(), 10),
+		})
+		return true
+	})
+	family("sqlwrapper_operations_total", "counter", "Operations by kind and outcome.", counts)
+
+	writeHistograms(w, "sqlwrapper_operation_duration_seconds", "Operation latency.", &m.ops, func(k interface{}) []string {
+		key := k.(opKey)
+		return []string{"db", key.db, "op", key.op}
+	})
+	writeHistograms(w, "sqlwrapper_query_duration_seconds", "Latency by query fingerprint.", &m.fps, func(k interface{}) []string {
+		key := k.(fpKey)
+		return []string{"db", key.db, "fingerprint", key.fingerprint, "op", key.op}
+	})
+
+	pools := m.poolStats()
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		var samples []sample
+		for db, st := range pools {
+			samples = append(samples, sample{labels("db", db), formatFloat(value(st))})
+		}
+		family(name, "gauge", help, samples)
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		var samples []sample
+		for db, st := range pools {
+			samples = append(samples, sample{labels("db", db), formatFloat(value(st))})
+		}
+		family(name, "counter", help, samples)
+	}
+	gauge("sqlwrapper_pool_max_open_connections", "Maximum number of open connections.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("sqlwrapper_pool_open_connections", "Open connections.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("sqlwrapper_pool_in_use_connections", "Connections in use.", func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("sqlwrapper_pool_idle_connections", "Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("sqlwrapper_pool_wait_count_total", "Connections waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("sqlwrapper_pool_wait_duration_seconds_total", "Time spent waiting for a connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("sqlwrapper_pool_max_idle_closed_total", "Connections closed by SetMaxIdleConns.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("sqlwrapper_pool_max_idle_time_closed_total", "Connections closed by SetConnMaxIdleTime.", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("sqlwrapper_pool_max_lifetime_closed_total", "Connections closed by SetConnMaxLifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+	return w.Flush()
+}
+
+func writeHistograms(w io.Writer, name, help string, m *sync.Map, keyLabels func(interface{}) []string) {
+	type entry struct {
+		labels []string
+		sorted string
+		h      *histogram
+	}
+	var entries []entry
+	m.Range(func(k, v interface{}) bool {
+		l := keyLabels(k)
+		entries = append(entries, entry{l, labels(l...), v.(*histogram)})
+		return true
+	})
+	if len(entries) == 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].sorted < entries[j].sorted })
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, e := range entries {
+		var cum uint64
+		for i := range e.h.counts {
+			cum += e.h.counts[i].Load()
+			le := "+Inf"
+			if i < len(metricBuckets) {
+				le = formatFloat(metricBuckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, labels(append(e.labels, "le", le)...), cum)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, e.sorted, formatFloat(time.Duration(e.h.sum.Load()).Seconds()))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, e.sorted, cum)
+	}
+}
+
+// labels renders name/value pairs as a label set.
+func labels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(kv[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Publish registers the metrics under name in expvar. Like expvar.Publish
+// it panics if name is already in use.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(m.expvar))
+}
+
+func (m *Metrics) expvar() interface{} {
+	type op struct {
+		DB      string `json:"db"`
+		Op      string `json:"op"`
+		Outcome string `json:"outcome"`
+		Count   uint64 `json:"count"`
+	}
+	var ops []op
+	m.counts.Range(func(k, v interface{}) bool {
+		key := k.(countKey)
+		ops = append(ops, op{DB: key.db, Op: key.op, Outcome: key.outcome, Count: v.(*atomic.Uint64).Load()})
+		return true
+	})
+	sort.Slice(ops, func(i, j int) bool {
+		a, b := ops[i], ops[j]
+		if a.DB != b.DB {
+			return a.DB < b.DB
+		}
+		if a.Op != b.Op {
+			return a.Op < b.Op
+		}
+		return a.Outcome < b.Outcome
+	})
+	latency := make(map[string]map[string]float64)
+	m.ops.Range(func(k, v interface{}) bool {
+		key := k.(opKey)
+		h := v.(*histogram)
+		if latency[key.db] == nil {
+			latency[key.db] = make(map[string]float64)
+		}
+		if n := h.count(); n > 0 {
+			latency[key.db][key.op] = time.Duration(h.sum.Load()).Seconds() / float64(n)
+		}
+		return true
+	})
+	return map[string]interface{}{
+		"operations":   ops,
+		"mean_seconds": latency,
+		"pools":        m.poolStats(),
+	}
+}
+
+type metricsHook struct {
+	m    *Metrics
+	name string
+}
+
+func (h *metricsHook) Before(ctx context.Context, e *Event) context.Context {
+	return ctx
+}
+
+func (h *metricsHook) After(ctx context.Context, e *Event) {
+	if e.Err == driver.ErrSkip {
+		return
+	}
+	h.m.record(h.name, e)
+}
@@ -0,0 +1,43 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestMetricsDuplicateName(t *testing.T) {
+	m := NewMetrics()
+	first, err := Wrap(sql.OpenDB(&fakeDriver{}), WithMetrics(m), WithLogger(NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Wrap(sql.OpenDB(&fakeDriver{}), WithMetrics(m), WithLogger(NewNopLogger())); err == nil {
+		t.Error("second DB with the default name accepted")
+	}
+	if _, err := WrapConnector(&fakeDriver{}, WithMetrics(m)); err == nil {
+		t.Error("connector with the default name accepted")
+	}
+	other, err := Wrap(sql.OpenDB(&fakeDriver{}), WithMetrics(m), WithName("other"), WithLogger(NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if n := len(m.poolStats()); n != 2 {
+		t.Errorf("%d pools, want 2", n)
+	}
+
+	first.Close()
+	again, err := Wrap(sql.OpenDB(&fakeDriver{}), WithMetrics(m), WithLogger(NewNopLogger()))
+	if err != nil {
+		t.Fatalf("name not released by Close: %v", err)
+	}
+	again.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := WrapConnector(&fakeDriver{}, WithMetrics(m), WithName("connector"), WithLogger(NewNopLogger()))
+		if err != nil {
+			t.Fatalf("connector %d: %v", i, err)
+		}
+		sql.OpenDB(c).Close()
+	}
+}
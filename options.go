@@ -0,0 +1,227 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultName is the value of the "name" field on every log entry.
+	DefaultName = "syhlion/sqlwrapper"
+	// DefaultSlowThreshold is used when WithSlowThreshold is not given.
+	DefaultSlowThreshold = time.Second
+)
+
+// Config is the resolved configuration of a DB.
+type Config struct {
+	Name          string
+	Debug         bool
+	SlowThreshold time.Duration
+	// LogLevel drops log entries below it. The default, LevelDebug, keeps
+	// everything.
+	LogLevel Level
+	Logger   Logger
+	Hooks    []Hook
+	// MaxAffectedRows, when positive, makes an UPDATE or DELETE that
+	// affects more rows than this log a warning.
+	MaxAffectedRows int64
+
+	StatsLimit          int
+	StatsReportInterval time.Duration
+	StatsReportTop      int
+
+	Metrics *Metrics
+	Retry   RetryPolicy
+	Dialect Dialect
+
+	Tracer Tracer
+	// TraceComment appends the W3C traceparent of each span to the SQL it
+	// traces, as a sqlcommenter comment.
+	TraceComment bool
+
+	PoolMonitor  *PoolMonitor
+	TxWatchdog   *TxWatchdog
+	Thresholds   *ThresholdPolicy
+	Redaction    *RedactionPolicy
+	Sampling     *SamplingPolicy
+	LeakDetector *LeakDetector
+}
+
+// Option configures a DB built by Open or Wrap.
+type Option func(*Config)
+
+// WithLogger sends events to l instead of the default JSON logger.
+func WithLogger(l Logger) Option {
+	return func(c *Config) {
+		c.Logger = l
+	}
+}
+
+// WithSlowThreshold logs every operation that takes at least d.
+// ThresholdDisabled turns slow logging off.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(c *Config) {
+		c.SlowThreshold = d
+	}
+}
+
+// WithDebug logs every operation regardless of its duration.
+func WithDebug(debug bool) Option {
+	return func(c *Config) {
+		c.Debug = debug
+	}
+}
+
+// WithLogLevel drops log entries below level.
+func WithLogLevel(level Level) Option {
+	return func(c *Config) {
+		c.LogLevel = level
+	}
+}
+
+// WithName sets the "name" field of log entries.
+func WithName(name string) Option {
+	return func(c *Config) {
+		c.Name = name
+	}
+}
+
+// WithMaxAffectedRows warns about UPDATE and DELETE statements affecting
+// more than n rows.
+func WithMaxAffectedRows(n int64) Option {
+	return func(c *Config) {
+		c.MaxAffectedRows = n
+	}
+}
+
+func newConfig(opts []Option) (*Config, error) {
+	c := &Config{
+		Name:          DefaultName,
+		SlowThreshold: DefaultSlowThreshold,
+		Logger:        defaultLogger(),
+		StatsLimit:    DefaultStatsLimit,
+		Retry:         DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Name == "" {
+		return errors.New("sqlwrapper: name must not be empty")
+	}
+	if c.SlowThreshold < 0 {
+		return errors.New("sqlwrapper: slow threshold must not be negative")
+	}
+	if !c.LogLevel.valid() {
+		return errors.New("sqlwrapper: unknown log level")
+	}
+	if c.MaxAffectedRows < 0 {
+		return errors.New("sqlwrapper: max affected rows must not be negative")
+	}
+	if c.StatsLimit < 0 {
+		return errors.New("sqlwrapper: stats limit must not be negative")
+	}
+	if c.StatsReportInterval < 0 || c.StatsReportInterval > 0 && c.StatsReportTop <= 0 {
+		return errors.New("sqlwrapper: stats report needs a positive interval and count")
+	}
+	if c.Logger == nil {
+		return errors.New("sqlwrapper: logger must not be nil")
+	}
+	switch c.Dialect {
+	case "", DialectMySQL, DialectPostgres, DialectSQLite:
+	default:
+		return errors.New("sqlwrapper: unknown dialect " + strconv.Quote(string(c.Dialect)))
+	}
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	if c.TxWatchdog != nil {
+		if err := c.TxWatchdog.validate(); err != nil {
+			return err
+		}
+	}
+	if c.LeakDetector != nil {
+		if err := c.LeakDetector.validate(); err != nil {
+			return err
+		}
+	}
+	if c.PoolMonitor != nil {
+		if err := c.PoolMonitor.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Thresholds != nil {
+		if err := c.Thresholds.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Redaction != nil {
+		if err := c.Redaction.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Sampling != nil {
+		if err := c.Sampling.validate(); err != nil {
+			return err
+		}
+	}
+	for _, h := range c.Hooks {
+		if h == nil {
+			return errors.New("sqlwrapper: hook must not be nil")
+		}
+	}
+	return nil
+}
+
+// Open opens a database with sql.Open and wraps it.
+func Open(driverName, dsn string, opts ...Option) (*DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d, err := Wrap(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Wrap instruments an already opened db.
+func Wrap(db *sql.DB, opts ...Option) (*DB, error) {
+	if db == nil {
+		return nil, errors.New("sqlwrapper: db must not be nil")
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Dialect == "" {
+		cfg.Dialect = detectDialect(db.Driver())
+	}
+	if cfg.Metrics != nil {
+		if err := cfg.Metrics.addPool(cfg.Name, db); err != nil {
+			return nil, err
+		}
+	}
+	c := newCore(cfg)
+	c.start()
+	if p := cfg.PoolMonitor; p != nil {
+		m := &poolMonitor{p: *p, db: db, prev: db.Stats()}
+		c.every(p.Interval, func() { m.report(c) })
+	}
+	return &DB{
+		db:   db,
+		core: c,
+	}, nil
+}
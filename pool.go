@@ -0,0 +1,91 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PoolMonitor configures the periodic report of the connection pool.
+type PoolMonitor struct {
+	// Interval is the time between two reports.
+	Interval time.Duration
+	// MaxWait warns when connection requests waited longer than this on
+	// average during an interval. Zero disables the check.
+	MaxWait time.Duration
+	// MaxSaturation warns when the share of connections in use, out of
+	// the maximum number of open connections, reaches this value between 0
+	// and 1. Zero disables the check; so does an unlimited pool.
+	MaxSaturation float64
+}
+
+// WithPoolMonitor logs the pool statistics every p.Interval: the current
+// connection counts and how the cumulative counters moved since the last
+// report. The report is a warning when a limit of p is crossed.
+func WithPoolMonitor(p PoolMonitor) Option {
+	return func(c *Config) {
+		c.PoolMonitor = &p
+	}
+}
+
+func (p *PoolMonitor) validate() error {
+	if p.Interval <= 0 {
+		return errors.New("sqlwrapper: pool monitor interval must be positive")
+	}
+	if p.MaxWait < 0 {
+		return errors.New("sqlwrapper: pool max wait must not be negative")
+	}
+	if p.MaxSaturation < 0 || p.MaxSaturation > 1 {
+		return errors.New("sqlwrapper: pool max saturation must be between 0 and 1")
+	}
+	return nil
+}
+
+// poolMonitor remembers the previous statistics to report deltas. It is
+// only used from the monitor goroutine.
+type poolMonitor struct {
+	p    PoolMonitor
+	db   *sql.DB
+	prev sql.DBStats
+}
+
+func (m *poolMonitor) report(c *core) {
+	s := m.db.Stats()
+	prev := m.prev
+	m.prev = s
+
+	waits := s.WaitCount - prev.WaitCount
+	waited := s.WaitDuration - prev.WaitDuration
+	f := Fields{
+		"ip":                   externalIP(),
+		"name":                 c.cfg.Name,
+		"open":                 s.OpenConnections,
+		"in-use":               s.InUse,
+		"idle":                 s.Idle,
+		"max-open":             s.MaxOpenConnections,
+		"wait-count":           waits,
+		"wait-time":            waited.String(),
+		"max-idle-closed":      s.MaxIdleClosed - prev.MaxIdleClosed,
+		"max-idle-time-closed": s.MaxIdleTimeClosed - prev.MaxIdleTimeClosed,
+		"max-lifetime-closed":  s.MaxLifetimeClosed - prev.MaxLifetimeClosed,
+		"interval":             m.p.Interval.String(),
+	}
+	level := LevelInfo
+	if waits > 0 {
+		mean := waited / time.Duration(waits)
+		f["mean-wait-time"] = mean.String()
+		if m.p.MaxWait > 0 && mean > m.p.MaxWait {
+			f["max-wait"] = m.p.MaxWait.String()
+			level = LevelWarn
+		}
+	}
+	if s.MaxOpenConnections > 0 {
+		saturation := float64(s.InUse) / float64(s.MaxOpenConnections)
+		f["saturation"] = saturation
+		if m.p.MaxSaturation > 0 && saturation >= m.p.MaxSaturation {
+			f["max-saturation"] = m.p.MaxSaturation
+			level = LevelWarn
+		}
+	}
+	c.log(level, "pool stats", f)
+}
@@ -0,0 +1,38 @@
+package sqlwrapper
+
+import "strings"
+
+// statementVerb returns the lower-cased first keyword of query, skipping
+// leading whitespace, comments and parentheses.
+func statementVerb(query string) string {
+	i := 0
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#':
+			if n := strings.IndexByte(query[i:], '\n'); n >= 0 {
+				i += n + 1
+			} else {
+				return ""
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if n := strings.Index(query[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				return ""
+			}
+		default:
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			return strings.ToLower(query[i:j])
+		}
+	}
+	return ""
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,220 @@
+package sqlwrapper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// RedactMode is how a redacted argument is logged.
+type RedactMode int
+
+const (
+	// RedactOmit logs "[redacted]" instead of the value.
+	RedactOmit RedactMode = iota
+	// RedactType logs the Go type of the value, e.g. "[string]".
+	RedactType
+	// RedactHash logs a digest of the value, so that equal values can be
+	// told apart from different ones without being revealed.
+	RedactHash
+)
+
+var (
+	// EmailPattern matches e-mail addresses.
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// CardPattern matches runs of 13 to 19 digits, optionally grouped with
+	// spaces or dashes, as payment card numbers are written.
+	CardPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+)
+
+// RedactionPolicy selects the query arguments that are masked in log
+// entries. An argument is redacted when any rule matches it.
+type RedactionPolicy struct {
+	Mode RedactMode
+	// All redacts every argument.
+	All bool
+	// Positions lists, per query fingerprint, the zero-based positions of
+	// the arguments to redact.
+	Positions map[string][]int
+	// Columns redacts the values bound to these columns, matched without
+	// regard to case. Columns are detected in INSERT column lists and in
+	// comparisons and assignments such as "password = ?"; the names of
+	// sql.Named arguments count too.
+	Columns []string
+	// Patterns redact string and []byte arguments they match anywhere,
+	// e.g. EmailPattern and CardPattern.
+	Patterns []*regexp.Regexp
+	// MaxLen truncates string and []byte arguments longer than MaxLen
+	// bytes. Zero means no limit.
+	MaxLen int
+	// HashKey keys the digests of RedactHash. Without a key, short values
+	// such as PINs can be recovered from their digest by brute force.
+	HashKey []byte
+}
+
+// WithRedaction masks logged query arguments according to p. Without it
+// arguments are logged as given.
+func WithRedaction(p RedactionPolicy) Option {
+	return func(c *Config) {
+		c.Redaction = &p
+	}
+}
+
+func (p *RedactionPolicy) validate() error {
+	if p.Mode < RedactOmit || p.Mode > RedactHash {
+		return fmt.Errorf("sqlwrapper: unknown redact mode %d", p.Mode)
+	}
+	if p.MaxLen < 0 {
+		return errors.New("sqlwrapper: redaction max length must not be negative")
+	}
+	for _, re := range p.Patterns {
+		if re == nil {
+			return errors.New("sqlwrapper: redaction pattern must not be nil")
+		}
+	}
+	return nil
+}
+
+type redactor struct {
+	p       RedactionPolicy
+	columns map[string]bool
+	dialect Dialect
+}
+
+// newRedactor returns nil when p is nil, which logs arguments as given.
+func newRedactor(p *RedactionPolicy, d Dialect) *redactor {
+	if p == nil {
+		return nil
+	}
+	r := &redactor{p: *p, columns: make(map[string]bool, len(p.Columns)), dialect: d}
+	for _, c := range p.Columns {
+		r.columns[strings.ToLower(c)] = true
+	}
+	return r
+}
+
+// args returns the arguments of e as they should be logged.
+func (r *redactor) args(e *Event) []interface{} {
+	if r == nil || len(e.Args) == 0 {
+		return e.Args
+	}
+	var cols []string
+	if len(r.columns) > 0 && e.rawQuery != "" {
+		cols = columnsOf(r.dialect, e.rawQuery)
+	}
+	positions := r.p.Positions[e.Fingerprint]
+	out := make([]interface{}, len(e.Args))
+	for i, v := range e.Args {
+		name := ""
+		if a, ok := v.(sql.NamedArg); ok {
+			name, v = a.Name, a.Value
+		}
+		switch {
+		case r.p.All,
+			containsInt(positions, i),
+			name != "" && r.columns[strings.ToLower(name)],
+			i < len(cols) && r.columns[cols[i]],
+			r.matches(v):
+			v = r.mask(v)
+		default:
+			v = r.truncate(v)
+		}
+		if name != "" {
+			v = sql.Named(name, v)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func (r *redactor) matches(v interface{}) bool {
+	for _, re := range r.p.Patterns {
+		switch v := v.(type) {
+		case string:
+			if re.MatchString(v) {
+				return true
+			}
+		case []byte:
+			if re.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *redactor) mask(v interface{}) interface{} {
+	switch r.p.Mode {
+	case RedactType:
+		return fmt.Sprintf("[%T]", v)
+	case RedactHash:
+		h := hmac.New(sha256.New, r.p.HashKey)
+		if b, ok := v.([]byte); ok {
+			h.Write(b)
+		} else {
+			fmt.Fprint(h, v)
+		}
+		return "sha256:" + hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return "[redacted]"
+}
+
+func (r *redactor) truncate(v interface{}) interface{} {
+	max := r.p.MaxLen
+	if max <= 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case string:
+		if len(v) > max {
+			n := max
+			for n > 0 && !utf8.RuneStart(v[n]) {
+				n--
+			}
+			return fmt.Sprintf("%s...(%d bytes)", v[:n], len(v))
+		}
+	case []byte:
+		if len(v) > max {
+			return fmt.Sprintf("%x...(%d bytes)", v[:max], len(v))
+		}
+	}
+	return v
+}
+
+func containsInt(s []int, n int) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+var queryColumns struct {
+	m sync.Map
+	n atomic.Int64
+}
+
+// columnsOf returns the columns of the arguments of query, from the cache
+// when possible.
+func columnsOf(d Dialect, query string) []string {
+	k := queryKey{d.lexer(), query}
+	if v, ok := queryColumns.m.Load(k); ok {
+		return v.([]string)
+	}
+	cols := k.dialect.Columns(query)
+	if queryColumns.n.Load() < maxCachedFingerprints {
+		if _, loaded := queryColumns.m.LoadOrStore(k, cols); !loaded {
+			queryColumns.n.Add(1)
+		}
+	}
+	return cols
+}
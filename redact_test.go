@@ -0,0 +1,32 @@
+package sqlwrapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactColumnsWithTraceComment(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log), WithDebug(true),
+		WithTracer(NewMemoryTracer()), WithTraceComment(true),
+		WithRedaction(RedactionPolicy{Columns: []string{"email"}}))
+	const query = "update users set email = ? where id = ?"
+	before := queryColumns.n.Load()
+	for i := 0; i < 5; i++ {
+		if _, err := db.Exec(query, "bob@example.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := queryColumns.n.Load() - before; n > 1 {
+		t.Errorf("%d column cache entries for one query", n)
+	}
+	entries := log.find("db exec")
+	if len(entries) != 5 {
+		t.Fatalf("%d db exec entries, want 5", len(entries))
+	}
+	for i, e := range entries {
+		if got, want := e.fields["args"], []interface{}{"[redacted]", i}; !reflect.DeepEqual(got, want) {
+			t.Errorf("args = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how RunInTx retries a transaction that failed with
+// a deadlock, a lock wait timeout or a serialization failure.
+type RetryPolicy struct {
+	// MaxAttempts is how many times the function runs at most, the first
+	// time included. 1 turns retries off.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles with every
+	// further retry, up to MaxBackoff. Each delay is drawn between half
+	// and all of that value, so that conflicting transactions do not retry
+	// in lockstep.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used when WithRetryPolicy is not given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+// WithRetryPolicy sets how RunInTx retries conflicting transactions.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Config) {
+		c.Retry = p
+	}
+}
+
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return errors.New("sqlwrapper: retry max attempts must be at least 1")
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("sqlwrapper: retry backoff must not be negative")
+	}
+	return nil
+}
+
+// delay returns the wait before the given retry, counted from 1.
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || d < p.MaxBackoff) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RunInTx runs fn in a transaction. The transaction is committed when fn
+// returns nil and rolled back when it returns an error or panics; a panic
+// is raised again after the rollback. When the transaction fails with an
+// error whose class is Retryable, fn is run again in a new transaction as
+// the retry policy allows, so fn must not have side effects outside of the
+// transaction. Each retry is logged with the ID of the failed transaction.
+//
+// fn gets a context carrying the transaction. RunInTx called with that
+// context runs its function in a savepoint of the same transaction
+// instead, ignoring opts and leaving retries to the outermost call.
+func (d *DB) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx *Tx) error) error {
+	if t := TxFromContext(ctx); t != nil && t.core == d.core {
+		return t.runNested(ctx, fn)
+	}
+	p := d.core.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		var txID int64
+		tx, err := d.BeginTX(ctx, opts)
+		if err == nil {
+			txID = tx.id
+			err = tx.run(ctx, fn)
+		}
+		class := ClassifyError(err)
+		if err == nil || !class.Retryable() || attempt >= p.MaxAttempts {
+			return err
+		}
+		delay := p.delay(attempt)
+		f := Fields{
+			"ip":           externalIP(),
+			"name":         d.core.cfg.Name,
+			"attempt":      attempt,
+			"max-attempts": p.MaxAttempts,
+			"backoff":      delay.String(),
+			"error":        err.Error(),
+			"error-class":  string(class),
+		}
+		if txID != 0 {
+			f["tx-id"] = txID
+		}
+		d.core.log(LevelWarn, "tx retry", f)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// run calls fn and commits, or rolls back when fn fails or panics.
+func (t *Tx) run(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) error {
+	ctx = context.WithValue(ctx, txKey{}, t)
+	defer func() {
+		if p := recover(); p != nil {
+			t.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(ctx, t); err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
@@ -0,0 +1,159 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// myError looks like a MySQL driver error.
+type myError struct{ Number uint16 }
+
+func (e *myError) Error() string { return "mysql error" }
+
+// pqError looks like a PostgreSQL driver error.
+type pqError struct{ code string }
+
+func (e *pqError) Error() string    { return "pq error" }
+func (e *pqError) SQLState() string { return e.code }
+
+var fastRetry = WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Microsecond, MaxBackoff: time.Millisecond})
+
+func checkCalls(t *testing.T, d *fakeDriver, want ...string) {
+	t.Helper()
+	if got := d.calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("driver calls = %q, want %q", got, want)
+	}
+}
+
+func TestRunInTxCommit(t *testing.T) {
+	db, d := openFake(t, false)
+	err := db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		if TxFromContext(ctx) != tx {
+			t.Error("tx not in context")
+		}
+		_, err := tx.ExecContext(ctx, "update t set a = 1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, d, "begin", "update t set a = 1", "commit")
+}
+
+func TestRunInTxRollback(t *testing.T) {
+	db, d := openFake(t, false, fastRetry)
+	boom := errors.New("boom")
+	calls := 0
+	err := db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		calls++
+		return boom
+	})
+	if err != boom || calls != 1 {
+		t.Errorf("err = %v after %d calls, want boom after 1", err, calls)
+	}
+	checkCalls(t, d, "begin", "rollback")
+}
+
+func TestRunInTxPanic(t *testing.T) {
+	db, d := openFake(t, false)
+	defer func() {
+		if p := recover(); p != "oops" {
+			t.Errorf("recovered %v, want oops", p)
+		}
+		checkCalls(t, d, "begin", "rollback")
+	}()
+	db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		panic("oops")
+	})
+	t.Error("panic swallowed")
+}
+
+func TestRunInTxRetry(t *testing.T) {
+	for _, conflict := range []error{&myError{1213}, &pqError{"40001"}} {
+		log := &memLogger{}
+		db, d := openFake(t, false, fastRetry, WithLogger(log))
+		d.fail("commit", conflict)
+		calls := 0
+		err := db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+			calls++
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("%v: err = %v after %d calls, want nil after 2", conflict, err, calls)
+		}
+		checkCalls(t, d, "begin", "commit", "begin", "commit")
+		if r := log.find("tx retry"); len(r) != 1 || r[0].fields["attempt"] != 1 {
+			t.Errorf("%v: retries logged as %v", conflict, r)
+		}
+	}
+}
+
+func TestRunInTxMaxAttempts(t *testing.T) {
+	db, _ := openFake(t, false, fastRetry)
+	conflict := &myError{1213}
+	calls := 0
+	err := db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		calls++
+		return conflict
+	})
+	if err != conflict || calls != 3 {
+		t.Errorf("err = %v after %d calls, want the conflict after 3", err, calls)
+	}
+}
+
+func TestRunInTxCanceledDuringBackoff(t *testing.T) {
+	db, _ := openFake(t, false, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conflict := &myError{1213}
+	calls := 0
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx *Tx) error {
+		calls++
+		return conflict
+	})
+	if err != conflict || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the conflict after 1", err, calls)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("backoff not interrupted by the context")
+	}
+}
+
+func TestRunInTxNested(t *testing.T) {
+	db, d := openFake(t, false)
+	inner := errors.New("inner")
+	err := db.RunInTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		if err := db.RunInTx(ctx, nil, func(ctx context.Context, tx *Tx) error { return nil }); err != nil {
+			return err
+		}
+		if err := db.RunInTx(ctx, nil, func(ctx context.Context, tx *Tx) error { return inner }); err != inner {
+			t.Errorf("nested err = %v, want inner", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, d, "begin",
+		"SAVEPOINT sqlwrapper_sp_1", "RELEASE SAVEPOINT sqlwrapper_sp_1",
+		"SAVEPOINT sqlwrapper_sp_2", "ROLLBACK TO SAVEPOINT sqlwrapper_sp_2",
+		"commit")
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 100, Backoff: time.Millisecond}
+	for retry := 1; retry < 100; retry++ {
+		if d := p.delay(retry); d <= 0 {
+			t.Fatalf("delay(%d) = %v", retry, d)
+		}
+	}
+	p.MaxBackoff = time.Second
+	if d := p.delay(50); d < time.Second/2 || d > time.Second {
+		t.Errorf("delay(50) = %v, want between 500ms and 1s", d)
+	}
+}
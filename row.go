@@ -0,0 +1,52 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Row wraps sql.Row. Its query row event is reported by the first Scan, so
+// the logged duration covers the whole round trip, or by Err if that comes
+// first. A Row that is neither scanned nor checked reports nothing, and its
+// span is never ended.
+type Row struct {
+	row  *sql.Row
+	core *core
+	ctx  context.Context
+	e    *Event
+	once sync.Once
+}
+
+func newRow(ctx context.Context, row *sql.Row, e *Event, c *core) *Row {
+	return &Row{
+		row:  row,
+		core: c,
+		ctx:  ctx,
+		e:    e,
+	}
+}
+
+// report ends the event of r, once.
+func (r *Row) report(err error, rowsRead int64) {
+	r.once.Do(func() {
+		r.e.Err = err
+		r.e.RowsRead = rowsRead
+		r.core.after(r.ctx, r.e)
+	})
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err == nil {
+		r.report(nil, 1)
+	} else {
+		r.report(err, 0)
+	}
+	return err
+}
+func (r *Row) Err() error {
+	err := r.row.Err()
+	r.report(err, 0)
+	return err
+}
@@ -0,0 +1,132 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Rows wraps sql.Rows and reports a fetch event when it is closed, covering
+// the time from the query to Close.
+type Rows struct {
+	rows *sql.Rows
+	core *core
+	ctx  context.Context
+	e    *Event
+	once sync.Once
+	res  *resource
+	// peeked is set when Next moved on to the next result set to learn
+	// whether the fetch was over; NextResultSet then only reports it.
+	peeked bool
+}
+
+func newFetchEvent(query *Event) *Event {
+	e := newEvent(query.Origin, OpFetch, "", query.Args)
+	e.Query, e.Fingerprint, e.Normalized, e.rawQuery = query.Query, query.Fingerprint, query.Normalized, query.rawQuery
+	e.Start = query.Start
+	e.ConnID, e.TxID = query.ConnID, query.TxID
+	e.tx = query.tx
+	return e
+}
+
+func newRows(ctx context.Context, rows *sql.Rows, query *Event, c *core) *Rows {
+	e := newFetchEvent(query)
+	r := &Rows{
+		rows: rows,
+		core: c,
+		ctx:  c.before(ctx, e),
+		e:    e,
+	}
+	if c.leaks != nil {
+		var parent *resource
+		if query.tx != nil {
+			parent = query.tx.res
+		}
+		r.res = c.track("rows", query, ctx.Done(), parent)
+	}
+	runtime.SetFinalizer(r, (*Rows).finalize)
+	return r
+}
+
+func (r *Rows) finalize() {
+	closed := true
+	r.once.Do(func() { closed = false })
+	if closed {
+		return
+	}
+	if r.res != nil {
+		r.core.leaked(r.res, "rows not closed")
+		r.res.close()
+	} else {
+		r.core.log(LevelWarn, "rows not closed", Fields{
+			"ip":   externalIP(),
+			"name": r.core.cfg.Name,
+			"sql":  r.e.Query,
+		})
+	}
+	r.rows.Close()
+}
+
+func (r *Rows) Next() bool {
+	if r.peeked {
+		return false
+	}
+	if !r.rows.Next() {
+		// Unless another result set follows, the fetch is over even if
+		// Close is never called: database/sql closes the rows itself at the
+		// end of the last result set, on errors and once the context is
+		// done.
+		if r.rows.Err() == nil && r.rows.NextResultSet() {
+			r.peeked = true
+		} else {
+			r.finish(nil)
+		}
+		return false
+	}
+	if r.e.RowsRead == 0 {
+		r.e.FirstRow = time.Since(r.e.Start)
+	}
+	r.e.RowsRead++
+	return true
+}
+func (r *Rows) NextResultSet() bool {
+	if r.peeked {
+		r.peeked = false
+	} else if !r.rows.NextResultSet() {
+		r.finish(nil)
+		return false
+	}
+	r.e.ResultSets++
+	return true
+}
+func (r *Rows) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+func (r *Rows) Err() error {
+	return r.rows.Err()
+}
+func (r *Rows) Columns() ([]string, error) {
+	return r.rows.Columns()
+}
+func (r *Rows) ColumnTypes() ([]*sql.ColumnType, error) {
+	return r.rows.ColumnTypes()
+}
+func (r *Rows) Close() error {
+	err := r.rows.Close()
+	r.finish(err)
+	return err
+}
+
+func (r *Rows) finish(err error) {
+	r.once.Do(func() {
+		runtime.SetFinalizer(r, nil)
+		r.res.close()
+		r.e.Err = r.rows.Err()
+		if r.e.Err == nil {
+			r.e.Err = err
+		}
+		r.core.after(r.ctx, r.e)
+	})
+}
@@ -0,0 +1,68 @@
+package sqlwrapper
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRowsCanceledWithoutClose(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log), WithDebug(true))
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, err := db.QueryContext(ctx, "select endless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	cancel()
+	for rows.Next() {
+	}
+	f := log.find("db fetch")
+	if len(f) != 1 {
+		t.Fatalf("%d fetch events, want 1", len(f))
+	}
+	if f[0].fields["error"] != context.Canceled.Error() {
+		t.Errorf("fetch error = %v, want %v", f[0].fields["error"], context.Canceled)
+	}
+	rows = nil
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+	if n := len(log.find("rows not closed")); n != 0 {
+		t.Errorf("%d rows not closed warnings for finished rows", n)
+	}
+}
+
+func TestRowsResultSets(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log), WithDebug(true))
+	rows, err := db.Query("select two sets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if len(log.find("db fetch")) != 0 {
+		t.Fatal("fetch reported before the last result set")
+	}
+	if !rows.NextResultSet() {
+		t.Fatal("second result set missing")
+	}
+	for rows.Next() {
+		n++
+	}
+	if rows.NextResultSet() {
+		t.Fatal("third result set")
+	}
+	f := log.find("db fetch")
+	if n != 4 || len(f) != 1 {
+		t.Fatalf("%d rows and %d fetch events, want 4 and 1", n, len(f))
+	}
+	if f[0].fields["rows"] != int64(4) || f[0].fields["result-sets"] != 1 {
+		t.Errorf("rows = %v, result-sets = %v, want 4 and 1", f[0].fields["rows"], f[0].fields["result-sets"])
+	}
+}
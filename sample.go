@@ -0,0 +1,184 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSamplingInterval is used when SamplingPolicy.Interval is zero.
+const DefaultSamplingInterval = time.Second
+
+// SamplingPolicy limits the log output of debug mode and slow events.
+// Failed operations and too-many-rows warnings are always logged.
+type SamplingPolicy struct {
+	// DebugRate is the fraction, between 0 and 1, of events logged only
+	// because debug mode is on that are kept. Zero keeps all of them.
+	DebugRate float64
+	// SlowBurst is how many slow events per fingerprint are logged each
+	// Interval. Zero means no limit.
+	SlowBurst int
+	// Interval is the window of SlowBurst and how often the number of
+	// dropped events is logged.
+	Interval time.Duration
+}
+
+// WithSampling samples and rate limits log output according to p. Every
+// Interval, a "suppressed N similar events" line reports what was dropped.
+func WithSampling(p SamplingPolicy) Option {
+	return func(c *Config) {
+		c.Sampling = &p
+	}
+}
+
+func (p *SamplingPolicy) validate() error {
+	if p.DebugRate < 0 || p.DebugRate > 1 || math.IsNaN(p.DebugRate) {
+		return errors.New("sqlwrapper: debug sampling rate must be between 0 and 1")
+	}
+	if p.SlowBurst < 0 {
+		return errors.New("sqlwrapper: slow burst must not be negative")
+	}
+	if p.Interval < 0 {
+		return errors.New("sqlwrapper: sampling interval must not be negative")
+	}
+	return nil
+}
+
+// sampleEntry counts the slow events of one fingerprint in the current
+// window.
+type sampleEntry struct {
+	normalized string
+	window     atomic.Pointer[sampleWindow]
+	suppressed atomic.Int64
+}
+
+// sampleWindow is replaced as a whole when a new window starts, so that no
+// event is counted against the wrong one.
+type sampleWindow struct {
+	start int64
+	count atomic.Int64
+}
+
+// sampler decides which events the log hook drops. The hot path is a
+// sync.Map lookup and a few atomic operations.
+type sampler struct {
+	rate     float64
+	burst    int64
+	interval time.Duration
+
+	debugSeen       atomic.Uint64
+	debugSuppressed atomic.Int64
+
+	n       atomic.Int64
+	entries sync.Map
+	other   sampleEntry
+}
+
+// newSampler returns nil when p is nil, which keeps every event.
+func newSampler(p *SamplingPolicy) *sampler {
+	if p == nil {
+		return nil
+	}
+	s := &sampler{
+		rate:     p.DebugRate,
+		burst:    int64(p.SlowBurst),
+		interval: p.Interval,
+	}
+	if s.rate == 0 {
+		s.rate = 1
+	}
+	if s.interval == 0 {
+		s.interval = DefaultSamplingInterval
+	}
+	return s
+}
+
+// keepDebug spreads the kept debug events evenly: the n-th event is kept
+// when n*rate reaches a new integer.
+func (s *sampler) keepDebug() bool {
+	if s == nil || s.rate >= 1 {
+		return true
+	}
+	n := s.debugSeen.Add(1)
+	if math.Floor(float64(n)*s.rate) != math.Floor(float64(n-1)*s.rate) {
+		return true
+	}
+	s.debugSuppressed.Add(1)
+	return false
+}
+
+func (s *sampler) keepSlow(e *Event) bool {
+	if s == nil || s.burst == 0 {
+		return true
+	}
+	se := s.entry(e)
+	start := e.Start.UnixNano() / int64(s.interval)
+	w := se.window.Load()
+	for w == nil || w.start < start {
+		next := &sampleWindow{start: start}
+		if se.window.CompareAndSwap(w, next) {
+			w = next
+			break
+		}
+		w = se.window.Load()
+	}
+	if w.count.Add(1) <= s.burst {
+		return true
+	}
+	se.suppressed.Add(1)
+	return false
+}
+
+func (s *sampler) entry(e *Event) *sampleEntry {
+	key := e.Fingerprint
+	if key == "" {
+		key = e.Name()
+	}
+	if v, ok := s.entries.Load(key); ok {
+		return v.(*sampleEntry)
+	}
+	if s.n.Load() >= maxCachedFingerprints {
+		return &s.other
+	}
+	v, loaded := s.entries.LoadOrStore(key, &sampleEntry{normalized: e.Normalized})
+	if !loaded {
+		s.n.Add(1)
+	}
+	return v.(*sampleEntry)
+}
+
+// report logs how many events were dropped since the last report.
+func (s *sampler) report(c *core) {
+	summary := func(n int64, f Fields) {
+		f["ip"] = externalIP()
+		f["name"] = c.cfg.Name
+		f["suppressed"] = n
+		f["interval"] = s.interval.String()
+		c.log(LevelInfo, fmt.Sprintf("suppressed %d similar events", n), f)
+	}
+	if n := s.debugSuppressed.Swap(0); n > 0 {
+		summary(n, Fields{"reason": "debug sampling"})
+	}
+	report := func(key string, se *sampleEntry) {
+		n := se.suppressed.Swap(0)
+		if n == 0 {
+			return
+		}
+		f := Fields{"reason": "slow burst"}
+		if se.normalized != "" {
+			f["fingerprint"] = key
+			f["sql"] = se.normalized
+		} else {
+			f["event"] = key
+		}
+		summary(n, f)
+	}
+	s.entries.Range(func(k, v interface{}) bool {
+		report(k.(string), v.(*sampleEntry))
+		return true
+	})
+	report("(other)", &s.other)
+}
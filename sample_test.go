@@ -0,0 +1,142 @@
+package sqlwrapper
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func slowEvent(fp string, start time.Time) *Event {
+	e := newEvent(OriginDB, OpExec, "update "+fp, nil)
+	e.Fingerprint, e.Normalized, e.Start = fp, "update "+fp, start
+	return e
+}
+
+func TestSampleSlowBurst(t *testing.T) {
+	s := newSampler(&SamplingPolicy{SlowBurst: 2, Interval: time.Second})
+	w0 := time.Unix(100, 0)
+	tests := []struct {
+		fp    string
+		start time.Time
+		keep  bool
+	}{
+		{"a", w0, true},
+		{"a", w0.Add(100 * time.Millisecond), true},
+		{"a", w0.Add(200 * time.Millisecond), false},
+		// Each fingerprint has its own burst.
+		{"b", w0.Add(300 * time.Millisecond), true},
+		{"a", w0.Add(900 * time.Millisecond), false},
+		// The next window starts over.
+		{"a", w0.Add(time.Second), true},
+		{"a", w0.Add(1100 * time.Millisecond), true},
+		{"a", w0.Add(1200 * time.Millisecond), false},
+		// A late event from an old window counts against the current one.
+		{"a", w0.Add(500 * time.Millisecond), false},
+	}
+	for i, tt := range tests {
+		if got := s.keepSlow(slowEvent(tt.fp, tt.start)); got != tt.keep {
+			t.Errorf("event %d (%s at +%v): keep = %v, want %v", i, tt.fp, tt.start.Sub(w0), got, tt.keep)
+		}
+	}
+}
+
+func TestSampleDebugRate(t *testing.T) {
+	s := newSampler(&SamplingPolicy{DebugRate: 0.25})
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if s.keepDebug() {
+			kept++
+		}
+	}
+	if kept != 25 {
+		t.Errorf("kept %d of 100 debug events, want 25", kept)
+	}
+	if n := s.debugSuppressed.Load(); n != 75 {
+		t.Errorf("%d suppressed, want 75", n)
+	}
+}
+
+func TestSampleReport(t *testing.T) {
+	log := &memLogger{}
+	db, _ := openFake(t, false, WithLogger(log))
+	s := newSampler(&SamplingPolicy{DebugRate: 0.5, SlowBurst: 1, Interval: time.Hour})
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		s.keepSlow(slowEvent("a", now))
+		s.keepDebug()
+	}
+	s.report(db.core)
+	got := log.find("suppressed 3 similar events")
+	if len(got) != 1 || got[0].fields["fingerprint"] != "a" || got[0].fields["sql"] != "update a" {
+		t.Errorf("slow summary = %v", got)
+	}
+	got = log.find("suppressed 2 similar events")
+	if len(got) != 1 || got[0].fields["reason"] != "debug sampling" {
+		t.Errorf("debug summary = %v", got)
+	}
+
+	// The counts start over after each report.
+	n := len(log.entries)
+	s.report(db.core)
+	if len(log.entries) != n {
+		t.Errorf("second report logged %v", log.entries[n:])
+	}
+}
+
+func TestSampleConcurrentBurst(t *testing.T) {
+	const burst, goroutines, events = 5, 16, 1000
+	for round := 0; round < 20; round++ {
+		s := newSampler(&SamplingPolicy{SlowBurst: burst, Interval: time.Hour})
+		start := time.Unix(3600, 0)
+		var kept atomic.Int64
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < events; i++ {
+					if s.keepSlow(slowEvent("a", start)) {
+						kept.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if n := kept.Load(); n != burst {
+			t.Fatalf("round %d: kept %d events in one window, want %d", round, n, burst)
+		}
+	}
+}
+
+func TestSampleConcurrentWindows(t *testing.T) {
+	const burst, goroutines, events = 5, 16, 1000
+	s := newSampler(&SamplingPolicy{SlowBurst: burst, Interval: time.Hour})
+	w0 := time.Unix(3600, 0)
+	var kept [2]atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				// Half of the events fall in the next window.
+				w := i % 2
+				if s.keepSlow(slowEvent("a", w0.Add(time.Duration(w)*time.Hour))) {
+					kept[w].Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// Once the second window started the first one's late events count
+	// against it, so only the total is exact per window.
+	total := kept[0].Load() + kept[1].Load()
+	if total < burst || total > 2*burst {
+		t.Errorf("kept %d and %d events, want at most %d per window", kept[0].Load(), kept[1].Load(), burst)
+	}
+	se := s.entry(slowEvent("a", w0))
+	if n := se.suppressed.Load(); n != goroutines*events-total {
+		t.Errorf("%d suppressed, want %d", n, goroutines*events-total)
+	}
+}
@@ -0,0 +1,132 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect selects the SQL flavour of the database, which decides how
+// queries are lexed for fingerprints and redaction.
+type Dialect string
+
+const (
+	DialectMySQL    Dialect = "mysql"
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// WithDialect sets the SQL dialect. Without it the dialect is guessed from
+// the package of the driver, falling back to standard SQL.
+func WithDialect(d Dialect) Option {
+	return func(c *Config) {
+		c.Dialect = d
+	}
+}
+
+// detectDialect guesses the dialect from the package path of drv.
+func detectDialect(drv driver.Driver) Dialect {
+	if w, ok := drv.(*wrappedDriver); ok {
+		drv = w.driver
+	}
+	t := reflect.TypeOf(drv)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	pkg := strings.ToLower(t.PkgPath())
+	switch {
+	case strings.Contains(pkg, "mysql"):
+		return DialectMySQL
+	case strings.Contains(pkg, "postgres"), strings.Contains(pkg, "pgx"), strings.HasSuffix(pkg, "/pq"):
+		return DialectPostgres
+	case strings.Contains(pkg, "sqlite"):
+		return DialectSQLite
+	}
+	return ""
+}
+
+// savepointSQL returns the statement of op. name is left unquoted, which
+// every dialect accepts once validSavepoint checked it.
+func savepointSQL(op Op, name string) string {
+	switch op {
+	case OpSavepoint:
+		return "SAVEPOINT " + name
+	case OpRollbackTo:
+		return "ROLLBACK TO SAVEPOINT " + name
+	}
+	return "RELEASE SAVEPOINT " + name
+}
+
+// validSavepoint accepts names made of ASCII letters, digits and
+// underscores, not starting with a digit, so they need no quoting.
+func validSavepoint(name string) bool {
+	if name == "" || len(name) > 63 || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Savepoint marks a point of t that RollbackTo can return to.
+func (t *Tx) Savepoint(ctx context.Context, name string) error {
+	return t.savepoint(ctx, OpSavepoint, name)
+}
+
+// RollbackTo undoes the work of t since the savepoint name. The savepoint
+// stays in place.
+func (t *Tx) RollbackTo(ctx context.Context, name string) error {
+	return t.savepoint(ctx, OpRollbackTo, name)
+}
+
+// Release forgets the savepoint name, keeping the work done since.
+func (t *Tx) Release(ctx context.Context, name string) error {
+	return t.savepoint(ctx, OpRelease, name)
+}
+
+func (t *Tx) savepoint(ctx context.Context, op Op, name string) error {
+	if !validSavepoint(name) {
+		return errors.New("sqlwrapper: invalid savepoint name " + strconv.Quote(name))
+	}
+	e := t.event(op, savepointSQL(op, name), nil)
+	ctx = t.core.before(ctx, e)
+	_, err := t.tx.ExecContext(ctx, e.Query)
+	e.Err = err
+	t.core.after(ctx, e)
+	return err
+}
+
+type txKey struct{}
+
+// TxFromContext returns the transaction RunInTx is running, or nil.
+func TxFromContext(ctx context.Context) *Tx {
+	t, _ := ctx.Value(txKey{}).(*Tx)
+	return t
+}
+
+// runNested runs fn inside a savepoint of t: the savepoint is released
+// when fn returns nil, and rolled back to when it fails or panics.
+func (t *Tx) runNested(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) (err error) {
+	name := "sqlwrapper_sp_" + strconv.FormatInt(int64(t.savepoints.Add(1)), 10)
+	if err := t.Savepoint(ctx, name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			t.RollbackTo(ctx, name)
+			panic(p)
+		}
+	}()
+	if err := fn(ctx, t); err != nil {
+		t.RollbackTo(ctx, name)
+		return err
+	}
+	return t.Release(ctx, name)
+}
@@ -0,0 +1,29 @@
+package sqlwrapper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSavepointSQL(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range []Dialect{"", DialectMySQL, DialectPostgres, DialectSQLite} {
+		db, fd := openFake(t, false, WithDialect(d))
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Savepoint(ctx, "Sp_1"); err != nil {
+			t.Fatal(err)
+		}
+		tx.RollbackTo(ctx, "Sp_1")
+		tx.Release(ctx, "Sp_1")
+		for _, name := range []string{"", "1sp", `a"b`, "a b", "a-b"} {
+			if err := tx.Savepoint(ctx, name); err == nil {
+				t.Errorf("savepoint %q accepted", name)
+			}
+		}
+		tx.Commit()
+		checkCalls(t, fd, "begin", "SAVEPOINT Sp_1", "ROLLBACK TO SAVEPOINT Sp_1", "RELEASE SAVEPOINT Sp_1", "commit")
+	}
+}
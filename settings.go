@@ -0,0 +1,142 @@
+package sqlwrapper
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// settings are the parts of the configuration that can change while the DB
+// is in use. They are replaced as a whole, so readers always see a
+// consistent set.
+type settings struct {
+	debug bool
+	slow  time.Duration
+	level Level
+}
+
+// update applies fn to a copy of the current settings and swaps it in.
+func (c *core) update(fn func(s *settings)) {
+	for {
+		old := c.live.Load()
+		s := *old
+		fn(&s)
+		if c.live.CompareAndSwap(old, &s) {
+			return
+		}
+	}
+}
+
+// log sends an entry to the logger unless it is below the current level.
+func (c *core) log(level Level, msg string, f Fields) {
+	if level < c.live.Load().level {
+		return
+	}
+	switch level {
+	case LevelDebug:
+		c.cfg.Logger.Debug(msg, f)
+	case LevelInfo:
+		c.cfg.Logger.Info(msg, f)
+	case LevelWarn:
+		c.cfg.Logger.Warn(msg, f)
+	default:
+		c.cfg.Logger.Error(msg, f)
+	}
+}
+
+// SetDebug turns debug logging on or off. Transactions and statements that
+// are already open see the change at once.
+func (d *DB) SetDebug(debug bool) {
+	d.core.update(func(s *settings) { s.debug = debug })
+}
+
+// SetSlowThreshold changes the default slow threshold. A negative value is
+// taken as ThresholdDisabled.
+func (d *DB) SetSlowThreshold(threshold time.Duration) {
+	if threshold < 0 {
+		threshold = ThresholdDisabled
+	}
+	d.core.update(func(s *settings) { s.slow = threshold })
+}
+
+// SetLogLevel drops log entries below level. Invalid levels are ignored.
+func (d *DB) SetLogLevel(level Level) {
+	if !level.valid() {
+		return
+	}
+	d.core.update(func(s *settings) { s.level = level })
+}
+
+type adminState struct {
+	Name          string `json:"name"`
+	Debug         bool   `json:"debug"`
+	SlowThreshold string `json:"slow-threshold"`
+	LogLevel      string `json:"log-level"`
+}
+
+// AdminHandler serves the runtime settings as JSON. A POST changes them
+// from the form values debug (a boolean), slow-threshold (a duration such
+// as "500ms", 0 to disable) and log-level; values not given are kept.
+func (d *DB) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			if err := d.applyForm(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := d.core.live.Load()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminState{
+			Name:          d.core.cfg.Name,
+			Debug:         s.debug,
+			SlowThreshold: s.slow.String(),
+			LogLevel:      s.level.String(),
+		})
+	})
+}
+
+// applyForm validates every value before changing anything.
+func (d *DB) applyForm(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	var fns []func(s *settings)
+	if v := r.Form.Get("debug"); v != "" {
+		debug, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		fns = append(fns, func(s *settings) { s.debug = debug })
+	}
+	if v := r.Form.Get("slow-threshold"); v != "" {
+		slow, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if slow < 0 {
+			slow = ThresholdDisabled
+		}
+		fns = append(fns, func(s *settings) { s.slow = slow })
+	}
+	if v := r.Form.Get("log-level"); v != "" {
+		level, err := ParseLevel(v)
+		if err != nil {
+			return err
+		}
+		fns = append(fns, func(s *settings) { s.level = level })
+	}
+	d.core.update(func(s *settings) {
+		for _, fn := range fns {
+			fn(s)
+		}
+	})
+	return nil
+}
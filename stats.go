@@ -0,0 +1,256 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql/driver"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStatsLimit is the number of distinct fingerprint and operation
+	// pairs tracked before new ones are folded into a single "other" entry.
+	DefaultStatsLimit = 1000
+
+	// Latencies are kept in exponential buckets growing by statGrowth from
+	// statBase, which bounds the percentile error to about 5% and keeps
+	// each entry at a fixed size whatever the traffic.
+	statBase    = float64(time.Microsecond)
+	statGrowth  = 1.1
+	statBuckets = 240
+)
+
+var statLogGrowth = math.Log(statGrowth)
+
+// QueryStat summarizes every call made with one query shape and operation.
+type QueryStat struct {
+	Fingerprint string
+	Normalized  string
+	Op          Op
+	Calls       int64
+	Errors      int64
+	Total       time.Duration
+	Min         time.Duration
+	Max         time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	P99         time.Duration
+}
+
+// Mean is the average duration of a call.
+func (s QueryStat) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+type statKey struct {
+	fingerprint string
+	op          Op
+}
+
+type statEntry struct {
+	normalized string
+	calls      atomic.Int64
+	errors     atomic.Int64
+	total      atomic.Int64
+	// min starts at math.MaxInt64 so that a zero duration is kept.
+	min     atomic.Int64
+	max     atomic.Int64
+	buckets [statBuckets]atomic.Uint64
+}
+
+func newStatEntry(normalized string) *statEntry {
+	s := &statEntry{normalized: normalized}
+	s.min.Store(math.MaxInt64)
+	return s
+}
+
+func (s *statEntry) record(d time.Duration, failed bool) {
+	ns := int64(d)
+	s.calls.Add(1)
+	if failed {
+		s.errors.Add(1)
+	}
+	s.total.Add(ns)
+	for {
+		m := s.min.Load()
+		if m <= ns || s.min.CompareAndSwap(m, ns) {
+			break
+		}
+	}
+	for {
+		m := s.max.Load()
+		if m >= ns || s.max.CompareAndSwap(m, ns) {
+			break
+		}
+	}
+	s.buckets[statBucket(d)].Add(1)
+}
+
+func statBucket(d time.Duration) int {
+	if float64(d) <= statBase {
+		return 0
+	}
+	i := int(math.Log(float64(d)/statBase)/statLogGrowth) + 1
+	if i >= statBuckets {
+		return statBuckets - 1
+	}
+	return i
+}
+
+// quantile returns the upper bound of the bucket holding the q-th call,
+// clamped to the observed min and max.
+func (s *statEntry) quantile(counts []uint64, n uint64, q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(n)))
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen < rank || c == 0 {
+			continue
+		}
+		d := time.Duration(statBase * math.Pow(statGrowth, float64(i)))
+		if min := time.Duration(s.min.Load()); d < min {
+			d = min
+		}
+		if max := time.Duration(s.max.Load()); d > max {
+			d = max
+		}
+		return d
+	}
+	return time.Duration(s.max.Load())
+}
+
+func (s *statEntry) snapshot(key statKey) QueryStat {
+	counts := make([]uint64, statBuckets)
+	var n uint64
+	for i := range s.buckets {
+		counts[i] = s.buckets[i].Load()
+		n += counts[i]
+	}
+	st := QueryStat{
+		Fingerprint: key.fingerprint,
+		Normalized:  s.normalized,
+		Op:          key.op,
+		Calls:       s.calls.Load(),
+		Errors:      s.errors.Load(),
+		Total:       time.Duration(s.total.Load()),
+		Max:         time.Duration(s.max.Load()),
+	}
+	if n > 0 {
+		st.Min = time.Duration(s.min.Load())
+		st.P50 = s.quantile(counts, n, 0.50)
+		st.P95 = s.quantile(counts, n, 0.95)
+		st.P99 = s.quantile(counts, n, 0.99)
+	}
+	return st
+}
+
+// statsHook aggregates events per fingerprint and operation. Lookups of
+// known entries go through a sync.Map and updates are atomic, so
+// concurrent callers never share a lock.
+type statsHook struct {
+	limit   int64
+	n       atomic.Int64
+	entries sync.Map
+	other   *statEntry
+}
+
+func newStatsHook(limit int) *statsHook {
+	return &statsHook{limit: int64(limit), other: newStatEntry("(other)")}
+}
+
+func (h *statsHook) Before(ctx context.Context, e *Event) context.Context {
+	return ctx
+}
+
+func (h *statsHook) After(ctx context.Context, e *Event) {
+	if e.Fingerprint == "" || e.Err == driver.ErrSkip {
+		return
+	}
+	h.entry(e).record(e.Duration, e.Failed())
+}
+
+func (h *statsHook) entry(e *Event) *statEntry {
+	key := statKey{e.Fingerprint, e.Op}
+	if v, ok := h.entries.Load(key); ok {
+		return v.(*statEntry)
+	}
+	if h.n.Load() >= h.limit {
+		return h.other
+	}
+	v, loaded := h.entries.LoadOrStore(key, newStatEntry(e.Normalized))
+	if !loaded {
+		h.n.Add(1)
+	}
+	return v.(*statEntry)
+}
+
+// snapshot returns every entry, most expensive in total first.
+func (h *statsHook) snapshot() []QueryStat {
+	var out []QueryStat
+	h.entries.Range(func(k, v interface{}) bool {
+		out = append(out, v.(*statEntry).snapshot(k.(statKey)))
+		return true
+	})
+	if h.other.calls.Load() > 0 {
+		out = append(out, h.other.snapshot(statKey{}))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Total > out[j].Total
+	})
+	return out
+}
+
+// report logs the top entries.
+func (h *statsHook) report(c *core) {
+	stats := h.snapshot()
+	if len(stats) > c.cfg.StatsReportTop {
+		stats = stats[:c.cfg.StatsReportTop]
+	}
+	for i, s := range stats {
+		c.log(LevelInfo, "query stats", Fields{
+			"ip":          externalIP(),
+			"name":        c.cfg.Name,
+			"rank":        i + 1,
+			"fingerprint": s.Fingerprint,
+			"sql":         s.Normalized,
+			"op":          string(s.Op),
+			"calls":       s.Calls,
+			"errors":      s.Errors,
+			"total-time":  s.Total.String(),
+			"mean-time":   s.Mean().String(),
+			"min-time":    s.Min.String(),
+			"max-time":    s.Max.String(),
+			"p50":         s.P50.String(),
+			"p95":         s.P95.String(),
+			"p99":         s.P99.String(),
+		})
+	}
+}
+
+// WithStatsLimit sets how many fingerprint and operation pairs QueryStats
+// tracks individually.
+func WithStatsLimit(n int) Option {
+	return func(c *Config) {
+		c.StatsLimit = n
+	}
+}
+
+// WithStatsReport logs the top n entries of QueryStats every interval.
+func WithStatsReport(interval time.Duration, n int) Option {
+	return func(c *Config) {
+		c.StatsReportInterval = interval
+		c.StatsReportTop = n
+	}
+}
+
+// QueryStats returns the aggregated statistics of every query shape seen so
+// far, most expensive in total first.
+func (d *DB) QueryStats() []QueryStat {
+	return d.core.stats.snapshot()
+}
@@ -0,0 +1,102 @@
+package sqlwrapper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatPercentiles(t *testing.T) {
+	ms := time.Millisecond
+	uniform := make([]time.Duration, 100)
+	for i := range uniform {
+		uniform[i] = time.Duration(i+1) * ms
+	}
+	bimodal := make([]time.Duration, 100)
+	for i := range bimodal {
+		bimodal[i] = ms
+		if i >= 90 {
+			bimodal[i] = time.Second
+		}
+	}
+	tests := []struct {
+		name          string
+		durations     []time.Duration
+		p50, p95, p99 time.Duration
+		min, max      time.Duration
+	}{
+		{"single", []time.Duration{5 * ms}, 5 * ms, 5 * ms, 5 * ms, 5 * ms, 5 * ms},
+		{"uniform", uniform, 50 * ms, 95 * ms, 99 * ms, ms, 100 * ms},
+		{"bimodal", bimodal, ms, time.Second, time.Second, ms, time.Second},
+		{"zero", []time.Duration{0, 0, 0, 2 * ms}, 0, 2 * ms, 2 * ms, 0, 2 * ms},
+		{"zero last", []time.Duration{3 * ms, 0}, 0, 3 * ms, 3 * ms, 0, 3 * ms},
+	}
+	// The upper bound of a bucket is at most statGrowth times its lower
+	// one, and the first bucket holds everything up to statBase.
+	near := func(got, want time.Duration) bool {
+		return got >= want && (float64(got) <= float64(want)*statGrowth || float64(got) <= statBase)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStatEntry("")
+			for _, d := range tt.durations {
+				s.record(d, false)
+			}
+			st := s.snapshot(statKey{})
+			if st.Min != tt.min || st.Max != tt.max {
+				t.Errorf("min, max = %v, %v, want %v, %v", st.Min, st.Max, tt.min, tt.max)
+			}
+			if !near(st.P50, tt.p50) || !near(st.P95, tt.p95) || !near(st.P99, tt.p99) {
+				t.Errorf("p50, p95, p99 = %v, %v, %v, want %v, %v, %v", st.P50, st.P95, st.P99, tt.p50, tt.p95, tt.p99)
+			}
+			if st.Calls != int64(len(tt.durations)) {
+				t.Errorf("calls = %d, want %d", st.Calls, len(tt.durations))
+			}
+		})
+	}
+}
+
+func TestStatConcurrentMinMax(t *testing.T) {
+	s := newStatEntry("")
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 1; i <= 1000; i++ {
+				s.record(time.Duration(g*1000+i)*time.Microsecond, i%10 == 0)
+			}
+		}(g)
+	}
+	wg.Wait()
+	st := s.snapshot(statKey{})
+	if st.Min != time.Microsecond || st.Max != 8000*time.Microsecond {
+		t.Errorf("min, max = %v, %v, want 1µs, 8ms", st.Min, st.Max)
+	}
+	if st.Calls != 8000 || st.Errors != 800 {
+		t.Errorf("calls, errors = %d, %d, want 8000, 800", st.Calls, st.Errors)
+	}
+}
+
+func TestStatsLimit(t *testing.T) {
+	h := newStatsHook(2)
+	ctx := context.Background()
+	for i, fp := range []string{"a", "b", "c", "d", "a"} {
+		h.After(ctx, &Event{Op: OpExec, Fingerprint: fp, Normalized: fp, Duration: time.Duration(i+1) * time.Millisecond})
+	}
+	calls := make(map[string]int64)
+	for _, s := range h.snapshot() {
+		calls[s.Normalized] = s.Calls
+	}
+	want := map[string]int64{"a": 2, "b": 1, "(other)": 2}
+	if len(calls) != len(want) {
+		t.Fatalf("entries = %v, want %v", calls, want)
+	}
+	for k, n := range want {
+		if calls[k] != n {
+			t.Errorf("entries = %v, want %v", calls, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+)
+
+type Stmt struct {
+	stmt    *sql.Stmt
+	prepare string
+	core    *core
+	// connID is the ID of the Conn the statement belongs to, if any.
+	connID int64
+	// tx is set for statements bound to a transaction.
+	tx *Tx
+	// parent is the statement a transaction-specific copy was made from.
+	parent *Stmt
+	res    *resource
+}
+
+func newStmt(c *core, stmt *sql.Stmt, query string, connID int64, tx *Tx) *Stmt {
+	s := &Stmt{
+		stmt:    stmt,
+		prepare: query,
+		core:    c,
+		connID:  connID,
+		tx:      tx,
+	}
+	if c.leaks != nil {
+		var parent *resource
+		if tx != nil {
+			parent = tx.res
+		}
+		s.res = c.track("stmt", s.event(OpPrepare, nil), nil, parent)
+		runtime.SetFinalizer(s, (*Stmt).finalize)
+	}
+	return s
+}
+
+func (s *Stmt) finalize() {
+	if s.res.open() {
+		s.core.leaked(s.res, "stmt not closed")
+		s.res.close()
+		s.stmt.Close()
+	}
+}
+
+func (s *Stmt) event(op Op, args []interface{}) *Event {
+	e := newEvent(OriginStmt, op, s.prepare, args)
+	e.ConnID = s.connID
+	if s.tx != nil {
+		e.TxID = s.tx.id
+	}
+	e.tx = s.tx
+	return e
+}
+
+// Parent returns the Stmt that Tx.Stmt made s from, or nil.
+func (s *Stmt) Parent() *Stmt {
+	return s.parent
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	e := s.event(OpExec, args)
+	ctx = s.core.before(ctx, e)
+	r, err := s.stmt.ExecContext(ctx, args...)
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	s.core.after(ctx, e)
+	return r, err
+}
+func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	e := s.event(OpQuery, args)
+	ctx = s.core.before(ctx, e)
+	rows, err := s.stmt.QueryContext(ctx, args...)
+	e.Err = err
+	s.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, rows, e, s.core), nil
+}
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
+	e := s.event(OpQueryRow, args)
+	ctx = s.core.before(ctx, e)
+	return newRow(ctx, s.stmt.QueryRowContext(ctx, args...), e, s.core)
+}
+func (s *Stmt) QueryRow(args ...interface{}) *Row {
+	return s.QueryRowContext(context.Background(), args...)
+}
+func (s *Stmt) Close() error {
+	e := s.event(OpClose, nil)
+	ctx := s.core.before(context.Background(), e)
+	err := s.stmt.Close()
+	s.res.close()
+	e.Err = err
+	s.core.after(ctx, e)
+	return err
+}
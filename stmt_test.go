@@ -0,0 +1,59 @@
+package sqlwrapper
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTxStmt(t *testing.T) {
+	log := &memLogger{}
+	db, d := openFake(t, false, WithLogger(log), WithDebug(true))
+	s, err := db.Prepare("update t set a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ts := tx.Stmt(s)
+	if ts.Parent() != s || s.Parent() != nil {
+		t.Errorf("Parent() = %p, want %p", ts.Parent(), s)
+	}
+	if _, err := ts.Exec(1); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := tx.Stmt(s).Exec(i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range log.find("stmt exec") {
+		if e.fields["tx-id"] != tx.ID() {
+			t.Errorf("tx-id = %v, want %d", e.fields["tx-id"], tx.ID())
+		}
+	}
+
+	// The original statement outlives the transaction.
+	if _, err := s.Exec(2); err != nil {
+		t.Fatalf("statement unusable after Commit: %v", err)
+	}
+	if e := log.find("stmt exec"); len(e) != 10 || e[9].fields["tx-id"] != nil {
+		t.Errorf("%d stmt exec events, the last one %v, want 10 ending outside the tx", len(e), e[len(e)-1].fields)
+	}
+	if got := d.calls(); got[len(got)-1] != "update t set a = ?" || len(got) != 12 {
+		t.Errorf("driver calls = %q", got)
+	}
+}
@@ -0,0 +1,130 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ThresholdDisabled turns slow logging off where a threshold is expected.
+const ThresholdDisabled time.Duration = 0
+
+// PatternThreshold applies Threshold to queries whose normalized text,
+// as returned by fingerprint.Normalize, matches Pattern.
+type PatternThreshold struct {
+	Pattern   *regexp.Regexp
+	Threshold time.Duration
+}
+
+// ThresholdPolicy overrides the slow threshold for some operations. The
+// most specific rule wins: a context override, then Fingerprints, then
+// the first matching entry of Patterns, then Ops and finally the
+// threshold given to WithSlowThreshold. A rule set to ThresholdDisabled
+// never logs as slow.
+type ThresholdPolicy struct {
+	Ops          map[Op]time.Duration
+	Fingerprints map[string]time.Duration
+	Patterns     []PatternThreshold
+}
+
+// WithThresholdPolicy sets per operation and per query slow thresholds.
+func WithThresholdPolicy(p ThresholdPolicy) Option {
+	return func(c *Config) {
+		c.Thresholds = &p
+	}
+}
+
+func (p *ThresholdPolicy) validate() error {
+	for _, d := range p.Ops {
+		if d < 0 {
+			return errors.New("sqlwrapper: slow threshold must not be negative")
+		}
+	}
+	for _, d := range p.Fingerprints {
+		if d < 0 {
+			return errors.New("sqlwrapper: slow threshold must not be negative")
+		}
+	}
+	for _, pt := range p.Patterns {
+		if pt.Pattern == nil {
+			return errors.New("sqlwrapper: threshold pattern must not be nil")
+		}
+		if pt.Threshold < 0 {
+			return errors.New("sqlwrapper: slow threshold must not be negative")
+		}
+	}
+	return nil
+}
+
+type thresholdKey struct{}
+
+// ContextWithSlowThreshold overrides the slow threshold of the operations
+// run with the returned context, e.g. for a report query known to be slow.
+func ContextWithSlowThreshold(ctx context.Context, d time.Duration) context.Context {
+	if d < 0 {
+		d = ThresholdDisabled
+	}
+	return context.WithValue(ctx, thresholdKey{}, d)
+}
+
+// thresholds resolves the slow threshold of an event.
+type thresholds struct {
+	p ThresholdPolicy
+
+	// matched caches, per fingerprint, the index of the first matching
+	// entry of p.Patterns, or -1.
+	matched sync.Map
+	n       atomic.Int64
+}
+
+func newThresholds(p *ThresholdPolicy) *thresholds {
+	t := &thresholds{}
+	if p != nil {
+		t.p = *p
+	}
+	return t
+}
+
+// of returns the threshold of e, or def when no rule applies.
+func (t *thresholds) of(ctx context.Context, e *Event, def time.Duration) time.Duration {
+	if d, ok := ctx.Value(thresholdKey{}).(time.Duration); ok {
+		return d
+	}
+	if e.Fingerprint != "" {
+		if d, ok := t.p.Fingerprints[e.Fingerprint]; ok {
+			return d
+		}
+		if i := t.pattern(e); i >= 0 {
+			return t.p.Patterns[i].Threshold
+		}
+	}
+	if d, ok := t.p.Ops[e.Op]; ok {
+		return d
+	}
+	return def
+}
+
+func (t *thresholds) pattern(e *Event) int {
+	if len(t.p.Patterns) == 0 {
+		return -1
+	}
+	if v, ok := t.matched.Load(e.Fingerprint); ok {
+		return v.(int)
+	}
+	i := -1
+	for j, pt := range t.p.Patterns {
+		if pt.Pattern.MatchString(e.Normalized) {
+			i = j
+			break
+		}
+	}
+	if t.n.Load() < maxCachedFingerprints {
+		if _, loaded := t.matched.LoadOrStore(e.Fingerprint, i); !loaded {
+			t.n.Add(1)
+		}
+	}
+	return i
+}
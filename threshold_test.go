@@ -0,0 +1,98 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestThresholdOrder(t *testing.T) {
+	const query = "select * from report_daily where id = ?"
+	fp, normalized := fingerprintOf(DialectPostgres, query)
+	ms := time.Millisecond
+	full := ThresholdPolicy{
+		Ops:          map[Op]time.Duration{OpQuery: 4 * ms},
+		Fingerprints: map[string]time.Duration{fp: 2 * ms},
+		Patterns: []PatternThreshold{
+			{regexp.MustCompile(`^select`), 3 * ms},
+			{regexp.MustCompile(`report_`), 9 * ms},
+		},
+	}
+	override := func(d time.Duration) context.Context {
+		return ContextWithSlowThreshold(context.Background(), d)
+	}
+	tests := []struct {
+		name string
+		p    ThresholdPolicy
+		ctx  context.Context
+		want time.Duration
+	}{
+		{"context", full, override(ms), ms},
+		{"negative context", full, override(-ms), ThresholdDisabled},
+		{"fingerprint", full, nil, 2 * ms},
+		{"first pattern", ThresholdPolicy{Ops: full.Ops, Patterns: full.Patterns}, nil, 3 * ms},
+		{"op", ThresholdPolicy{Ops: full.Ops}, nil, 4 * ms},
+		{"default", ThresholdPolicy{Ops: map[Op]time.Duration{OpExec: ms}}, nil, 5 * ms},
+		{"context disabled", full, override(ThresholdDisabled), ThresholdDisabled},
+		{"fingerprint disabled", ThresholdPolicy{Fingerprints: map[string]time.Duration{fp: ThresholdDisabled}, Patterns: full.Patterns}, nil, ThresholdDisabled},
+		{"pattern disabled", ThresholdPolicy{Patterns: []PatternThreshold{{regexp.MustCompile(`report_`), ThresholdDisabled}}, Ops: full.Ops}, nil, ThresholdDisabled},
+		{"op disabled", ThresholdPolicy{Ops: map[Op]time.Duration{OpQuery: ThresholdDisabled}}, nil, ThresholdDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.p
+			th := newThresholds(&p)
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			e := newEvent(OriginDB, OpQuery, query, nil)
+			e.Fingerprint, e.Normalized = fp, normalized
+			if got := th.of(ctx, e, 5*ms); got != tt.want {
+				t.Errorf("threshold = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdDisabledLogsNothing(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		ctx  context.Context
+		logs int
+	}{
+		{"default disabled", []Option{WithSlowThreshold(ThresholdDisabled)}, context.Background(), 0},
+		{"op disabled", []Option{WithSlowThreshold(time.Nanosecond),
+			WithThresholdPolicy(ThresholdPolicy{Ops: map[Op]time.Duration{OpExec: ThresholdDisabled}})}, context.Background(), 0},
+		{"context disabled", []Option{WithSlowThreshold(time.Nanosecond)},
+			ContextWithSlowThreshold(context.Background(), ThresholdDisabled), 0},
+		{"tiny threshold", []Option{WithSlowThreshold(time.Nanosecond)}, context.Background(), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &memLogger{}
+			db, _ := openFake(t, false, append(tt.opts, WithLogger(log))...)
+			if _, err := db.ExecContext(tt.ctx, "update t set a = 1"); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(log.find("db exec")); n != tt.logs {
+				t.Errorf("%d db exec entries, want %d", n, tt.logs)
+			}
+		})
+	}
+}
+
+func TestWrapperDBZeroSlow(t *testing.T) {
+	log := &memLogger{}
+	db := WrapperDB(sql.OpenDB(&fakeDriver{}), false, 0, log)
+	defer db.Close()
+	if _, err := db.Exec("update t set a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(log.entries); n != 0 {
+		t.Errorf("WrapperDB(db, false, 0) logged %d entries, want none", n)
+	}
+}
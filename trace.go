@@ -0,0 +1,265 @@
+package sqlwrapper
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer opens spans around operations. It mirrors the parts of the
+// OpenTelemetry API sqlwrapper needs, so an adapter is a few lines of code
+// without this package importing OpenTelemetry.
+type Tracer interface {
+	// Start opens a span named name, child of the span carried by ctx.
+	Start(ctx context.Context, name string, start time.Time) (context.Context, Span)
+	// ContextWithSpan returns ctx carrying span as the current span.
+	ContextWithSpan(ctx context.Context, span Span) context.Context
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End(end time.Time)
+	// TraceParent returns the W3C traceparent header value of the span, or
+	// an empty string if it has none.
+	TraceParent() string
+}
+
+// WithTracer opens a span for every operation. Spans of statements run in a
+// transaction are children of the transaction's span.
+func WithTracer(t Tracer) Option {
+	return func(c *Config) {
+		c.Tracer = t
+	}
+}
+
+// WithTraceComment appends the traceparent of each span to the SQL sent to
+// the database as a sqlcommenter comment, so the database's own logs can be
+// joined with the traces. Prepared statements are left alone.
+func WithTraceComment(on bool) Option {
+	return func(c *Config) {
+		c.TraceComment = on
+	}
+}
+
+type spanKey struct{}
+
+type spanState struct {
+	span Span
+	// parent is the context the span was started from.
+	parent context.Context
+}
+
+type traceHook struct {
+	tracer  Tracer
+	comment bool
+}
+
+func (h *traceHook) Before(ctx context.Context, e *Event) context.Context {
+	parent := ctx
+	if e.tx != nil && e.tx.span != nil {
+		parent = h.tracer.ContextWithSpan(ctx, e.tx.span)
+	}
+	ctx, span := h.tracer.Start(parent, e.Name(), e.Start)
+	span.SetAttribute("db.operation", spanOperation(e))
+	if e.ConnID != 0 {
+		span.SetAttribute("db.connection_id", e.ConnID)
+	}
+	if e.Query != "" {
+		span.SetAttribute("db.statement", e.Query)
+		span.SetAttribute("db.sql.fingerprint", e.Fingerprint)
+		if h.comment && injectable(e) {
+			e.Query = appendTraceParent(e.Query, span.TraceParent())
+		}
+	}
+	return context.WithValue(ctx, spanKey{}, &spanState{span: span, parent: parent})
+}
+
+func (h *traceHook) After(ctx context.Context, e *Event) {
+	st, ok := ctx.Value(spanKey{}).(*spanState)
+	if !ok {
+		return
+	}
+	if e.Op == OpExec && e.RowsAffected >= 0 {
+		st.span.SetAttribute("db.rows_affected", e.RowsAffected)
+	}
+	if e.Op == OpFetch || e.Op == OpQueryRow {
+		st.span.SetAttribute("db.rows_read", e.RowsRead)
+	}
+	if e.Failed() {
+		st.span.RecordError(e.Err)
+		st.span.SetAttribute("error.class", string(e.ErrClass))
+	}
+	end := e.Start.Add(e.Duration)
+	st.span.End(end)
+
+	if e.tx == nil || e.Err == driver.ErrSkip {
+		return
+	}
+	switch {
+	case e.Op == OpBegin && e.Err == nil:
+		_, e.tx.span = h.tracer.Start(st.parent, "tx", e.Start)
+	case e.Op == OpCommit || e.Op == OpRollback:
+		if s := e.tx.span; s != nil && e.tx.spanEnded.CompareAndSwap(false, true) {
+			s.SetAttribute("db.tx.outcome", string(e.Op))
+			if e.Failed() {
+				s.RecordError(e.Err)
+			}
+			s.End(end)
+		}
+	}
+}
+
+// spanOperation is the SQL verb for statements and the operation kind for
+// everything else.
+func spanOperation(e *Event) string {
+	if e.Query != "" && e.Op != OpPrepare && e.Op != OpClose {
+		if v := statementVerb(e.Query); v != "" {
+			return strings.ToUpper(v)
+		}
+	}
+	return string(e.Op)
+}
+
+// injectable reports whether the SQL of e is sent as is, rather than
+// prepared once and reused.
+func injectable(e *Event) bool {
+	switch e.Origin {
+	case OriginDB, OriginTx, OriginConn, OriginDriverConn:
+	default:
+		return false
+	}
+	switch e.Op {
+	case OpExec, OpQuery, OpQueryRow:
+		return true
+	}
+	return false
+}
+
+// appendTraceParent adds a sqlcommenter comment to query, ahead of any
+// trailing semicolon.
+func appendTraceParent(query, traceparent string) string {
+	if traceparent == "" || strings.Contains(query, "traceparent=") {
+		return query
+	}
+	body := strings.TrimRight(query, " \t\r\n;")
+	return body + " /*traceparent='" + traceparent + "'*/" + query[len(body):]
+}
+
+// MemoryTracer records spans in memory, for tests.
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+// NewMemoryTracer returns an empty MemoryTracer.
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// MemorySpan is a span recorded by a MemoryTracer. Its fields are only
+// stable once Ended is set; use MemoryTracer.Spans to read them.
+type MemorySpan struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Start      time.Time
+	End        time.Time
+	Ended      bool
+	Attributes map[string]interface{}
+	Errors     []error
+
+	tracer *MemoryTracer
+}
+
+type memorySpan struct {
+	*MemorySpan
+}
+
+type memorySpanKey struct{}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, start time.Time) (context.Context, Span) {
+	s := &MemorySpan{
+		Name:       name,
+		SpanID:     randomHex(8),
+		Start:      start,
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+	if parent, ok := ctx.Value(memorySpanKey{}).(*MemorySpan); ok {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	span := memorySpan{s}
+	return context.WithValue(ctx, memorySpanKey{}, s), span
+}
+
+func (t *MemoryTracer) ContextWithSpan(ctx context.Context, span Span) context.Context {
+	if s, ok := span.(memorySpan); ok {
+		return context.WithValue(ctx, memorySpanKey{}, s.MemorySpan)
+	}
+	return ctx
+}
+
+// Spans returns a copy of every span started so far, in start order.
+func (t *MemoryTracer) Spans() []MemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]MemorySpan, len(t.spans))
+	for i, s := range t.spans {
+		out[i] = *s
+		out[i].Attributes = make(map[string]interface{}, len(s.Attributes))
+		for k, v := range s.Attributes {
+			out[i].Attributes[k] = v
+		}
+		out[i].Errors = append([]error(nil), s.Errors...)
+	}
+	return out
+}
+
+// Reset forgets every recorded span.
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	t.spans = nil
+	t.mu.Unlock()
+}
+
+func (s memorySpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	s.Attributes[key] = value
+	s.tracer.mu.Unlock()
+}
+
+func (s memorySpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	s.Errors = append(s.Errors, err)
+	s.tracer.mu.Unlock()
+}
+
+func (s memorySpan) End(end time.Time) {
+	s.tracer.mu.Lock()
+	s.MemorySpan.End = end
+	s.Ended = true
+	s.tracer.mu.Unlock()
+}
+
+func (s memorySpan) TraceParent() string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
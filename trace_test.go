@@ -0,0 +1,57 @@
+package sqlwrapper
+
+import "testing"
+
+// span returns the only span named name.
+func span(t *testing.T, spans []MemorySpan, name string) MemorySpan {
+	t.Helper()
+	var found []MemorySpan
+	for _, s := range spans {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("%d %q spans, want 1", len(found), name)
+	}
+	return found[0]
+}
+
+func TestTraceTx(t *testing.T) {
+	tr := NewMemoryTracer()
+	db, _ := openFake(t, false, WithTracer(tr))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("update t set a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// A mistaken second Commit gets its own span, not the transaction's.
+	tx.Commit()
+
+	spans := tr.Spans()
+	txSpan := span(t, spans, "tx")
+	if !txSpan.Ended || txSpan.Attributes["db.tx.outcome"] != "commit" || len(txSpan.Errors) != 0 {
+		t.Errorf("tx span ended=%v outcome=%v errors=%v, want a clean commit",
+			txSpan.Ended, txSpan.Attributes["db.tx.outcome"], txSpan.Errors)
+	}
+	if exec := span(t, spans, "tx exec"); exec.ParentID != txSpan.SpanID || exec.TraceID != txSpan.TraceID {
+		t.Errorf("tx exec span is not a child of the tx span")
+	}
+}
+
+func TestTraceQueryRowErr(t *testing.T) {
+	tr := NewMemoryTracer()
+	db, _ := openFake(t, false, WithTracer(tr))
+	if err := db.QueryRow("select 1").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s := span(t, tr.Spans(), "db query row"); !s.Ended {
+		t.Error("query row span not ended by Err")
+	}
+}
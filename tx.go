@@ -0,0 +1,128 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+)
+
+// txIDs numbers the transactions of the process, starting at 1.
+var txIDs atomic.Int64
+
+type Tx struct {
+	tx   *sql.Tx
+	core *core
+	id   int64
+	// connID is the ID of the Conn the transaction was begun on, if any.
+	connID int64
+	// savepoints numbers the savepoints made by nested RunInTx calls.
+	savepoints atomic.Int32
+	state      txState
+	// span is the tracing span covering the whole transaction. spanEnded
+	// makes sure only the first Commit or Rollback ends it.
+	span      Span
+	spanEnded atomic.Bool
+	res       *resource
+}
+
+// ID is the TxID of the events of t.
+func (t *Tx) ID() int64 {
+	return t.id
+}
+
+func (t *Tx) event(op Op, query string, args []interface{}) *Event {
+	e := newEvent(OriginTx, op, query, args)
+	e.ConnID, e.TxID = t.connID, t.id
+	e.tx = t
+	return e
+}
+
+func (t *Tx) Commit() error {
+	e := t.event(OpCommit, "", nil)
+	ctx := t.core.before(context.Background(), e)
+	err := t.tx.Commit()
+	t.state.ended.Store(true)
+	t.res.close()
+	e.Err = err
+	t.core.after(ctx, e)
+	return err
+}
+
+// Rollback rolls t back. Once t was committed or rolled back it only returns
+// sql.ErrTxDone and reports nothing, so a deferred Rollback after Commit
+// stays quiet.
+func (t *Tx) Rollback() error {
+	if t.state.ended.Load() {
+		return t.tx.Rollback()
+	}
+	e := t.event(OpRollback, "", nil)
+	ctx := t.core.before(context.Background(), e)
+	err := t.tx.Rollback()
+	t.state.ended.Store(true)
+	t.res.close()
+	e.Err = err
+	t.core.after(ctx, e)
+	return err
+}
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e := t.event(OpExec, query, args)
+	ctx = t.core.before(ctx, e)
+	r, err := t.tx.ExecContext(ctx, e.Query, args...)
+	e.Err = err
+	if err == nil {
+		e.setResult(r)
+	}
+	t.core.after(ctx, e)
+	return r, err
+}
+func (t *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.ExecContext(context.Background(), query, args...)
+}
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := t.event(OpQuery, query, args)
+	ctx = t.core.before(ctx, e)
+	rows, err := t.tx.QueryContext(ctx, e.Query, args...)
+	e.Err = err
+	t.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, rows, e, t.core), nil
+}
+func (t *Tx) Query(query string, args ...interface{}) (*Rows, error) {
+	return t.QueryContext(context.Background(), query, args...)
+}
+func (t *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	e := t.event(OpQueryRow, query, args)
+	ctx = t.core.before(ctx, e)
+	return newRow(ctx, t.tx.QueryRowContext(ctx, e.Query, args...), e, t.core)
+}
+func (t *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return t.QueryRowContext(context.Background(), query, args...)
+}
+func (t *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	e := t.event(OpPrepare, query, nil)
+	ctx = t.core.before(ctx, e)
+	s, err := t.tx.PrepareContext(ctx, query)
+	e.Err = err
+	t.core.after(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newStmt(t.core, s, query, t.connID, t), nil
+}
+func (t *Tx) Prepare(query string) (*Stmt, error) {
+	return t.PrepareContext(context.Background(), query)
+}
+func (t *Tx) Stmt(stmt *Stmt) *Stmt {
+	return t.StmtContext(context.Background(), stmt)
+}
+
+// StmtContext returns a transaction-specific copy of stmt, closed with the
+// transaction. stmt itself is left as it is and can still be used outside
+// of t.
+func (t *Tx) StmtContext(ctx context.Context, stmt *Stmt) *Stmt {
+	s := newStmt(t.core, t.tx.StmtContext(ctx, stmt.stmt), stmt.prepare, t.connID, t)
+	s.parent = stmt
+	return s
+}